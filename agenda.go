@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	agendaDays    = 7
+	maxAgendaDays = 366
+)
+
+// agendaText holds the labels of the agenda by language.
+var agendaText = map[string]map[string]string{
+	"ru": {
+		"title":    "Задачи на %s – %s",
+		"overdue":  "Просрочено",
+		"empty":    "Задач нет",
+		"daily":    "каждый день",
+		"days":     "каждые %d дн.",
+		"yearly":   "каждый год",
+		"date":     "02.01.2006",
+		"weekdays": "Воскресенье,Понедельник,Вторник,Среда,Четверг,Пятница,Суббота",
+		"months":   "Январь,Февраль,Март,Апрель,Май,Июнь,Июль,Август,Сентябрь,Октябрь,Ноябрь,Декабрь",
+		"marked":   "* — повторяющаяся задача",
+	},
+	"en": {
+		"title":    "Agenda %s – %s",
+		"overdue":  "Overdue",
+		"empty":    "No tasks",
+		"daily":    "every day",
+		"days":     "every %d days",
+		"yearly":   "every year",
+		"date":     "2006-01-02",
+		"weekdays": "Sunday,Monday,Tuesday,Wednesday,Thursday,Friday,Saturday",
+		"months":   "January,February,March,April,May,June,July,August,September,October,November,December",
+		"marked":   "* — repeating task",
+	},
+}
+
+var mdEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`<`, `\<`, `>`, `\>`, `#`, `\#`, `|`, `\|`)
+
+// agenda renders the tasks of a period grouped by day as Markdown or, with
+// format=text, as plain text. The period is a week from today by default.
+func (s *Server) agenda(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	var contentType string
+	switch format {
+	case "", "md":
+		format, contentType = "md", "text/markdown; charset=utf-8"
+	case "text":
+		contentType = "text/plain; charset=utf-8"
+	default:
+		writeError(w, r, &APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: "format", Err: ErrBadFormat})
+		return
+	}
+
+	from, to, err := agendaPeriod(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	agenda, err := s.m.Agenda(from, to)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	lang := language(r)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language")
+	w.Write([]byte(renderAgenda(agenda, format == "md", lang)))
+}
+
+// agendaPeriod reads the from and to dates, to defaults to a week after from.
+// Past days are not planned, so from may not be earlier than today.
+func agendaPeriod(r *http.Request) (string, string, error) {
+	start := time.Now()
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse("20060102", v)
+		if err != nil || v < start.Format("20060102") {
+			return "", "", &APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: "from", Err: ErrBadFormat}
+		}
+		start = t
+	}
+	end := start.AddDate(0, 0, agendaDays-1)
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse("20060102", v)
+		if err != nil {
+			return "", "", &APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: "to", Err: ErrBadFormat}
+		}
+		end = t
+	}
+
+	from, to := start.Format("20060102"), end.Format("20060102")
+	if to < from || to > start.AddDate(0, 0, maxAgendaDays-1).Format("20060102") {
+		return "", "", &APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: "to", Err: ErrBadFormat}
+	}
+	return from, to, nil
+}
+
+func agendaLabels(lang string) map[string]string {
+	if text, ok := agendaText[lang]; ok {
+		return text
+	}
+	return agendaText[defaultLang]
+}
+
+func renderAgenda(a *Agenda, md bool, lang string) string {
+	text := agendaLabels(lang)
+
+	var b strings.Builder
+	agendaHeading(&b, fmt.Sprintf(text["title"], agendaDate(a.From, text), agendaDate(a.To, text)), 1, md)
+
+	if len(a.Overdue) > 0 {
+		agendaHeading(&b, text["overdue"], 2, md)
+		for _, t := range a.Overdue {
+			agendaTask(&b, t, agendaDate(t.Date, text), md, text)
+		}
+	}
+	for _, day := range a.Days {
+		agendaHeading(&b, agendaDay(day.Date, text), 2, md)
+		for _, t := range day.Tasks {
+			agendaTask(&b, t, "", md, text)
+		}
+	}
+	if len(a.Overdue) == 0 && len(a.Days) == 0 {
+		b.WriteString(text["empty"] + "\n")
+	}
+	return b.String()
+}
+
+func agendaHeading(b *strings.Builder, title string, level int, md bool) {
+	if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n\n") {
+		b.WriteString("\n")
+	}
+	if md {
+		b.WriteString(strings.Repeat("#", level) + " " + mdEscaper.Replace(title) + "\n\n")
+		return
+	}
+	b.WriteString(title + "\n")
+	if level == 1 {
+		b.WriteString(strings.Repeat("=", len([]rune(title))) + "\n")
+	}
+	b.WriteString("\n")
+}
+
+// agendaTask writes a task line, date is set for overdue tasks. The comment
+// follows on indented lines.
+func agendaTask(b *strings.Builder, t Task, date string, md bool, text map[string]string) {
+	title := strings.Join(strings.Fields(t.Title), " ")
+	var extra []string
+	if date != "" {
+		extra = append(extra, date)
+	}
+	if t.Repeat != "" {
+		extra = append(extra, "↻ "+repeatText(t.Repeat, text))
+	}
+
+	if md {
+		b.WriteString("- [ ] " + mdEscaper.Replace(title))
+		if len(extra) > 0 {
+			b.WriteString(" _(" + mdEscaper.Replace(strings.Join(extra, ", ")) + ")_")
+		}
+	} else {
+		b.WriteString("  * " + title)
+		if len(extra) > 0 {
+			b.WriteString(" (" + strings.Join(extra, ", ") + ")")
+		}
+	}
+	b.WriteString("\n")
+
+	if comment := strings.TrimSpace(t.Comment); comment != "" {
+		for _, line := range strings.Split(comment, "\n") {
+			line = strings.TrimSpace(line)
+			if md {
+				line = mdEscaper.Replace(line)
+			}
+			b.WriteString("    " + line + "\n")
+		}
+	}
+}
+
+// agendaDay returns the weekday and the date.
+func agendaDay(date string, text map[string]string) string {
+	d, err := time.Parse("20060102", date)
+	if err != nil {
+		return date
+	}
+	return strings.Split(text["weekdays"], ",")[d.Weekday()] + ", " + d.Format(text["date"])
+}
+
+func agendaDate(date string, text map[string]string) string {
+	d, err := time.Parse("20060102", date)
+	if err != nil {
+		return date
+	}
+	return d.Format(text["date"])
+}
+
+func repeatText(repeat string, text map[string]string) string {
+	rule, err := ParseRepeat(repeat)
+	switch {
+	case err != nil:
+		return repeat
+	case rule.Type == "y":
+		return text["yearly"]
+	case rule.Days == 1:
+		return text["daily"]
+	default:
+		return fmt.Sprintf(text["days"], rule.Days)
+	}
+}
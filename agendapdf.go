@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// The Go fonts are embedded, they cover Cyrillic and need no files on the
+// server.
+const (
+	pdfFont   = "go"
+	pdfMargin = 12.0
+	pdfLine   = 6.0
+)
+
+type pdfAgenda struct {
+	*fpdf.Fpdf
+	text map[string]string
+	days map[string][]Task
+}
+
+// agendaPDF renders the agenda of a period for printing: a page per day,
+// a page per week (the default) or a calendar grid per month.
+func (s *Server) agendaPDF(w http.ResponseWriter, r *http.Request) {
+	layout := r.URL.Query().Get("layout")
+	switch layout {
+	case "":
+		layout = "week"
+	case "day", "week", "month":
+	default:
+		writeError(w, r, &APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: "layout", Err: ErrBadFormat})
+		return
+	}
+
+	from, to, err := agendaPeriod(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	agenda, err := s.m.Agenda(from, to)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err = renderAgendaPDF(&buf, agenda, layout, language(r)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="agenda-`+from+`.pdf"`)
+	w.Write(buf.Bytes())
+}
+
+// renderAgendaPDF lays out the days from a.From to a.To, the period the
+// service planned; days outside it would print as empty pages.
+func renderAgendaPDF(buf *bytes.Buffer, a *Agenda, layout, lang string) error {
+	text := agendaLabels(lang)
+	start, err := time.Parse("20060102", a.From)
+	if err != nil {
+		return ErrBadDate
+	}
+	end, err := time.Parse("20060102", a.To)
+	if err != nil {
+		return ErrBadDate
+	}
+
+	orientation := "P"
+	if layout == "month" {
+		orientation = "L"
+	}
+	title := fmt.Sprintf(text["title"], agendaDate(a.From, text), agendaDate(a.To, text))
+	p := &pdfAgenda{Fpdf: fpdf.New(orientation, "mm", "A4", ""), text: text, days: make(map[string][]Task)}
+	p.AddUTF8FontFromBytes(pdfFont, "", goregular.TTF)
+	p.AddUTF8FontFromBytes(pdfFont, "B", gobold.TTF)
+	p.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	p.SetAutoPageBreak(true, pdfMargin+4)
+	p.SetTitle(title, true)
+	p.AliasNbPages("")
+	p.SetFooterFunc(func() {
+		p.SetY(-pdfMargin)
+		p.SetFont(pdfFont, "", 8)
+		p.SetTextColor(120, 120, 120)
+		p.CellFormat(0, 4, title+"   "+strconv.Itoa(p.PageNo())+"/{nb}", "", 0, "R", false, 0, "")
+		p.SetTextColor(0, 0, 0)
+	})
+	for _, day := range a.Days {
+		p.days[day.Date] = day.Tasks
+	}
+
+	switch layout {
+	case "day":
+		for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+			p.AddPage()
+			if d.Equal(start) {
+				p.overdue(a.Overdue)
+			}
+			p.day(d)
+		}
+	case "week":
+		for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+			if d.Equal(start) || d.Weekday() == time.Monday {
+				p.AddPage()
+				last := d.AddDate(0, 0, (7-int(d.Weekday()))%7)
+				if last.After(end) {
+					last = end
+				}
+				p.heading(fmt.Sprintf(text["title"], d.Format(text["date"]), last.Format(text["date"])), 16)
+			}
+			if d.Equal(start) {
+				p.overdue(a.Overdue)
+			}
+			p.day(d)
+		}
+	case "month":
+		if len(a.Overdue) > 0 {
+			p.AddPage()
+			p.overdue(a.Overdue)
+		}
+		for m := start.AddDate(0, 0, 1-start.Day()); !m.After(end); m = m.AddDate(0, 1, 0) {
+			p.AddPage()
+			p.month(m, start, end)
+		}
+	}
+	return p.Output(buf)
+}
+
+func (p *pdfAgenda) heading(title string, size float64) {
+	p.SetFont(pdfFont, "B", size)
+	p.MultiCell(0, size*0.5, title, "", "L", false)
+	p.Ln(2)
+}
+
+func (p *pdfAgenda) overdue(tasks []Task) {
+	if len(tasks) == 0 {
+		return
+	}
+	p.heading(p.text["overdue"], 13)
+	for _, t := range tasks {
+		p.task(t, agendaDate(t.Date, p.text))
+	}
+	p.Ln(3)
+}
+
+func (p *pdfAgenda) day(d time.Time) {
+	p.breakBefore(2 * pdfLine)
+	p.heading(agendaDay(d.Format("20060102"), p.text), 13)
+	left, _, right, _ := p.GetMargins()
+	width, _ := p.GetPageSize()
+	p.Line(left, p.GetY()-1, width-right, p.GetY()-1)
+	p.Ln(1)
+
+	tasks := p.days[d.Format("20060102")]
+	if len(tasks) == 0 {
+		p.SetFont(pdfFont, "", 11)
+		p.SetTextColor(120, 120, 120)
+		p.CellFormat(0, pdfLine, "—", "", 1, "L", false, 0, "")
+		p.SetTextColor(0, 0, 0)
+	}
+	for _, t := range tasks {
+		p.task(t, "")
+	}
+	p.Ln(3)
+}
+
+// task writes a checkbox with the title, then the date of an overdue task,
+// the repeat rule and the comment in small print.
+func (p *pdfAgenda) task(t Task, date string) {
+	p.breakBefore(pdfLine)
+	left, _, _, _ := p.GetMargins()
+	y := p.GetY()
+	p.Rect(left, y+1.2, 3.6, 3.6, "D")
+	p.SetXY(left+6, y)
+	p.SetFont(pdfFont, "", 11)
+	p.MultiCell(0, pdfLine, strings.Join(strings.Fields(t.Title), " "), "", "L", false)
+
+	var small []string
+	if date != "" {
+		small = append(small, date)
+	}
+	if t.Repeat != "" {
+		small = append(small, repeatText(t.Repeat, p.text))
+	}
+	p.SetFont(pdfFont, "", 9)
+	p.SetTextColor(100, 100, 100)
+	if len(small) > 0 {
+		p.SetX(left + 6)
+		p.MultiCell(0, 4.5, strings.Join(small, ", "), "", "L", false)
+	}
+	if comment := strings.TrimSpace(t.Comment); comment != "" {
+		p.SetX(left + 6)
+		p.MultiCell(0, 4.5, comment, "", "L", false)
+	}
+	p.SetTextColor(0, 0, 0)
+	p.Ln(1.5)
+}
+
+// breakBefore starts a new page unless h millimetres fit on this one.
+func (p *pdfAgenda) breakBefore(h float64) {
+	_, height := p.GetPageSize()
+	_, _, _, bottom := p.GetMargins()
+	if p.GetY()+h > height-bottom {
+		p.AddPage()
+	}
+}
+
+// month draws the calendar of month, days outside of start and end are
+// greyed out. Titles are cut to the cell width, a cell lists as many tasks
+// as fit and the number of the rest.
+func (p *pdfAgenda) month(month, start, end time.Time) {
+	const lineH = 3.6
+
+	left, _, right, _ := p.GetMargins()
+	width, height := p.GetPageSize()
+	p.SetAutoPageBreak(false, 0)
+	defer p.SetAutoPageBreak(true, pdfMargin+4)
+
+	p.SetFont(pdfFont, "B", 16)
+	name := strings.Split(p.text["months"], ",")[month.Month()-1]
+	p.CellFormat(0, 9, name+" "+strconv.Itoa(month.Year()), "", 1, "L", false, 0, "")
+
+	cw := (width - left - right) / 7
+	weekdays := strings.Split(p.text["weekdays"], ",")
+	p.SetFont(pdfFont, "B", 9)
+	for i := 0; i < 7; i++ {
+		p.CellFormat(cw, 6, weekdays[(i+1)%7], "1", 0, "C", false, 0, "")
+	}
+	p.Ln(-1)
+
+	offset := (int(month.Weekday()) + 6) % 7
+	days := month.AddDate(0, 1, -1).Day()
+	rows := (offset + days + 6) / 7
+	top := p.GetY()
+	ch := (height - pdfMargin - 10 - top) / float64(rows)
+	maxLines := int((ch - 6) / lineH)
+
+	for i := 0; i < rows*7; i++ {
+		x, y := left+float64(i%7)*cw, top+float64(i/7)*ch
+		p.Rect(x, y, cw, ch, "D")
+		d := month.AddDate(0, 0, i-offset)
+		if d.Month() != month.Month() {
+			continue
+		}
+
+		inRange := !d.Before(start) && !d.After(end)
+		if !inRange {
+			p.SetTextColor(170, 170, 170)
+		}
+		p.SetXY(x+1, y+1)
+		p.SetFont(pdfFont, "B", 9)
+		p.CellFormat(cw-2, 4, strconv.Itoa(d.Day()), "", 0, "R", false, 0, "")
+		p.SetTextColor(0, 0, 0)
+		if !inRange {
+			continue
+		}
+
+		p.SetFont(pdfFont, "", 7)
+		tasks := p.days[d.Format("20060102")]
+		for j, t := range tasks {
+			label := "□ " + strings.Join(strings.Fields(t.Title), " ")
+			if t.Repeat != "" {
+				label += " *"
+			}
+			if j == maxLines-1 && len(tasks) > maxLines {
+				label = "+" + strconv.Itoa(len(tasks)-j)
+			}
+			p.SetXY(x+1, y+5.5+float64(j)*lineH)
+			p.CellFormat(cw-2, lineH, p.fit(label, cw-2), "", 0, "L", false, 0, "")
+			if j == maxLines-1 {
+				break
+			}
+		}
+	}
+
+	p.SetXY(left, top+float64(rows)*ch+1)
+	p.SetFont(pdfFont, "", 7)
+	p.CellFormat(0, 4, p.text["marked"], "", 0, "L", false, 0, "")
+}
+
+// fit cuts s to width w adding an ellipsis.
+func (p *pdfAgenda) fit(s string, w float64) string {
+	if p.GetStringWidth(s) <= w {
+		return s
+	}
+	r := []rune(s)
+	for len(r) > 0 && p.GetStringWidth(string(r)+"…") > w {
+		r = r[:len(r)-1]
+	}
+	return string(r) + "…"
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// APIError is an error with the HTTP status and the stable machine-readable
+// code it is reported with. Field names the invalid request field, if any,
+// Hint is the catalogue code of a more specific message for that field.
+type APIError struct {
+	Status  int
+	Code    string
+	Field   string
+	Hint    string
+	Details map[string]string
+	Err     error
+}
+
+func (e *APIError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+type errorBody struct {
+	Error   string            `json:"error"`
+	Code    string            `json:"code"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// apiErrors maps the sentinels from errors.go to their API representation.
+var apiErrors = []APIError{
+	{Status: http.StatusBadRequest, Code: "id_required", Err: ErrEmptyId},
+	{Status: http.StatusBadRequest, Code: "invalid_id", Err: ErrId},
+	{Status: http.StatusBadRequest, Code: "bad_format", Err: ErrBadFormat},
+	{Status: http.StatusForbidden, Code: "forbidden", Err: ErrForbidden},
+	{Status: http.StatusNotFound, Code: "task_not_found", Err: ErrSearchTask},
+	{Status: http.StatusNotFound, Code: "task_not_found", Err: ErrRows},
+	{Status: http.StatusNotFound, Code: "revision_not_found", Err: ErrSearchRev},
+	{Status: http.StatusNotFound, Code: "webhook_not_found", Err: ErrSearchHook},
+	{Status: http.StatusNotFound, Code: "delivery_not_found", Err: ErrSearchDelivery},
+	{Status: http.StatusNotFound, Code: "calendar_token_not_found", Err: ErrSearchCalToken},
+	{Status: http.StatusPreconditionFailed, Code: "version_mismatch", Err: ErrVersion},
+	{Status: http.StatusPreconditionRequired, Code: "if_match_required", Err: ErrNoIfMatch},
+	{Status: http.StatusUnprocessableEntity, Code: "idempotency_key_reused", Err: ErrIdemReused},
+	{Status: http.StatusConflict, Code: "idempotency_key_in_progress", Err: ErrIdemBusy},
+	{Status: http.StatusConflict, Code: "task_locked", Err: ErrLocked},
+	{Status: http.StatusUnprocessableEntity, Code: "title_required", Field: "title", Err: ErrEmptyTitle},
+	{Status: http.StatusUnprocessableEntity, Code: "date_required", Field: "date", Err: ErrEmptyDate},
+	{Status: http.StatusUnprocessableEntity, Code: "invalid_date", Field: "date", Err: ErrBadDate},
+	{Status: http.StatusUnprocessableEntity, Code: "invalid_repeat", Field: "repeat", Err: ErrBadVal},
+	{Status: http.StatusUnprocessableEntity, Code: "invalid_task", Err: ErrBadTask},
+	{Status: http.StatusUnprocessableEntity, Code: "invalid_url", Field: "url", Err: ErrHookURL},
+	{Status: http.StatusUnprocessableEntity, Code: "invalid_event", Field: "events", Err: ErrHookEvent},
+	{Status: http.StatusUnprocessableEntity, Code: "name_required", Field: "name", Err: ErrEmptyName},
+	{Status: http.StatusUnprocessableEntity, Code: "invalid_calendar", Err: ErrBadCalendar},
+	{Status: http.StatusRequestEntityTooLarge, Code: "file_too_large", Err: ErrTooLarge},
+	{Status: http.StatusUnprocessableEntity, Code: "completed", Err: ErrCompleted},
+	{Status: http.StatusUnprocessableEntity, Code: "past_event", Err: ErrPastEvent},
+	{Status: http.StatusForbidden, Code: "invalid_sync_token", Err: ErrSyncToken},
+	{Status: http.StatusUnprocessableEntity, Code: "invalid_file", Err: ErrBadImport},
+	{Status: http.StatusConflict, Code: "task_exists", Err: ErrTaskExists},
+	{Status: http.StatusUnprocessableEntity, Code: "invalid_operation", Err: ErrBatchOp},
+	{Status: http.StatusRequestEntityTooLarge, Code: "batch_too_large", Err: ErrBatchSize},
+}
+
+func newAPIError(status int, code string, err error) *APIError {
+	return &APIError{Status: status, Code: code, Err: err}
+}
+
+// toAPIError classifies err. Unknown errors become an opaque 500 so that
+// database details don't leak to clients.
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	var ruleErr *RuleError
+	if errors.As(err, &ruleErr) {
+		return &APIError{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    "invalid_repeat",
+			Field:   "repeat",
+			Hint:    "repeat_" + ruleErr.Reason,
+			Details: map[string]string{"part": ruleErr.Part, "value": ruleErr.Value, "reason": ruleErr.Reason},
+			Err:     ErrBadVal,
+		}
+	}
+	var schemaErr *schemaError
+	if errors.As(err, &schemaErr) {
+		return &APIError{
+			Status:  http.StatusBadRequest,
+			Code:    "schema_violation",
+			Field:   schemaErr.Path,
+			Hint:    "schema_" + schemaErr.Reason,
+			Details: map[string]string{"path": schemaErr.Path, "reason": schemaErr.Reason},
+			Err:     ErrSchema,
+		}
+	}
+	for i := range apiErrors {
+		if errors.Is(err, apiErrors[i].Err) {
+			e := apiErrors[i]
+			return &e
+		}
+	}
+	return &APIError{Status: http.StatusInternalServerError, Code: "internal", Err: ErrSqlExec}
+}
+
+// writeError reports err in the language negotiated for the request, using
+// the v2 envelope for /api/v2 routes.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if strings.HasPrefix(r.URL.Path, "/api/v2/") {
+		writeErrorV2(w, r, err)
+		return
+	}
+	status, body := errorResponse(w, r, err)
+	writeJSON(w, status, body)
+}
+
+func errorResponse(w http.ResponseWriter, r *http.Request, err error) (int, errorBody) {
+	e := toAPIError(err)
+	if e.Status >= http.StatusInternalServerError {
+		log.Printf("Request failed: %v", err)
+	}
+
+	lang := language(r)
+	msg := message(lang, e.Code, e.Err)
+
+	body := errorBody{Error: msg, Code: e.Code, Details: e.Details}
+	if e.Field != "" {
+		fieldMsg := msg
+		if e.Hint != "" {
+			fieldMsg = message(lang, e.Hint, e.Err)
+		}
+		body.Fields = map[string]string{e.Field: fieldMsg}
+	}
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language")
+	return e.Status, body
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	res, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		status = http.StatusInternalServerError
+		res = []byte(`{"error":"` + ErrBadFormat.Error() + `","code":"internal"}`)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	w.Write(res)
+}
+
+// decodeJSON reads the request body into v reporting malformed input as 400.
+func decodeJSON(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return newAPIError(http.StatusBadRequest, "invalid_json", ErrBadFormat)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Backup copies a consistent snapshot of the database to dest using the
+// SQLite online backup API, so it is safe while the server is writing.
+func (s *Storage) Backup(dest string) error {
+	ctx := context.Background()
+
+	destDB, err := sql.Open("sqlite3", dest)
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+
+	destConn, err := destDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	srcConn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(dc any) error {
+		return srcConn.Raw(func(sc any) error {
+			b, err := dc.(*sqlite3.SQLiteConn).Backup("main", sc.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+
+			for {
+				done, err := b.Step(-1)
+				if err != nil {
+					b.Finish()
+					return err
+				}
+				if done {
+					break
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+			return b.Finish()
+		})
+	})
+}
+
+// checkBackup makes sure path is an intact scheduler database this build can migrate.
+func checkBackup(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	d, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return ErrOpenDB
+	}
+	defer d.Close()
+
+	var check string
+	if err = d.QueryRow("PRAGMA integrity_check").Scan(&check); err != nil || check != "ok" {
+		return ErrBadBackup
+	}
+
+	var version int
+	if err = d.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return ErrBadBackup
+	}
+	if version < 1 || version > len(migrations) {
+		return ErrSchemaVer
+	}
+
+	var tables int
+	err = d.QueryRow("SELECT count(*) FROM sqlite_master WHERE type='table' AND name='scheduler'").Scan(&tables)
+	if err != nil || tables == 0 {
+		return ErrBadBackup
+	}
+	return nil
+}
+
+// Restore replaces the database file with the backup at src. The server must be stopped.
+func Restore(src string) error {
+	if err := checkBackup(src); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dbName), ".restore-*.db")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	// The old database is kept as .bak together with its rollback journal
+	// or WAL files, SQLite needs them to open it consistently.
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		if err = os.Remove(dbName + ".bak" + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if _, err = os.Stat(dbName + suffix); err != nil {
+			continue
+		}
+		if err = os.Rename(dbName+suffix, dbName+".bak"+suffix); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(tmp.Name(), dbName)
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The scheduler has a single task list, it is the calendar collection
+// davCollection of the principal davRoot.
+const (
+	davRoot       = "/caldav/"
+	davCollection = "/caldav/tasks/"
+	davSyncPrefix = "http://todo-scheduler/sync/"
+
+	nsDAV    = "DAV:"
+	nsCalDAV = "urn:ietf:params:xml:ns:caldav"
+	nsCS     = "http://calendarserver.org/ns/"
+)
+
+var davPrefixes = map[string]string{nsDAV: "D", nsCalDAV: "C", nsCS: "CS"}
+
+// davAllProps are returned for allprop and empty PROPFIND requests.
+var davAllProps = []xml.Name{
+	{Space: nsDAV, Local: "resourcetype"},
+	{Space: nsDAV, Local: "displayname"},
+	{Space: nsDAV, Local: "getetag"},
+	{Space: nsDAV, Local: "getcontenttype"},
+}
+
+type davKind int
+
+const (
+	davKindRoot davKind = iota
+	davKindCollection
+	davKindObject
+)
+
+type davResource struct {
+	kind  davKind
+	href  string
+	token int64
+	obj   *CalDAVObject
+}
+
+// davRequest holds what the server uses from PROPFIND and REPORT bodies.
+type davRequest struct {
+	XMLName   xml.Name
+	AllProp   *struct{}     `xml:"DAV: allprop"`
+	Prop      *davPropNames `xml:"DAV: prop"`
+	Hrefs     []string      `xml:"DAV: href"`
+	SyncToken string        `xml:"DAV: sync-token"`
+	Filter    *struct {
+		Comp davCompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	} `xml:"urn:ietf:params:xml:ns:caldav filter"`
+}
+
+type davPropNames struct {
+	Names []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+type davCompFilter struct {
+	Name  string          `xml:"name,attr"`
+	Comps []davCompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+}
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	DAV       string        `xml:"xmlns:D,attr"`
+	CalDAV    string        `xml:"xmlns:C,attr"`
+	CS        string        `xml:"xmlns:CS,attr"`
+	Responses []davResponse `xml:"D:response"`
+	SyncToken string        `xml:"D:sync-token,omitempty"`
+}
+
+type davResponse struct {
+	Href     string        `xml:"D:href"`
+	Propstat []davPropstat `xml:"D:propstat,omitempty"`
+	Status   string        `xml:"D:status,omitempty"`
+}
+
+type davPropstat struct {
+	Prop   davPropList `xml:"D:prop"`
+	Status string      `xml:"D:status"`
+}
+
+type davPropList struct {
+	Props []davProp
+}
+
+// davProp is a property of a response, Value is its XML content.
+type davProp struct {
+	XMLName xml.Name
+	Value   string `xml:",innerxml"`
+}
+
+// davAuth accepts the admin token as a bearer token or as the Basic
+// authentication password, which is what CalDAV clients support.
+func (s *Server) davAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if _, password, ok := r.BasicAuth(); ok {
+			header = "Bearer " + password
+		}
+		if !authorized(s.adminToken, header) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="todo-scheduler"`)
+			writeError(w, r, newAPIError(http.StatusUnauthorized, "forbidden", ErrForbidden))
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) caldav(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("DAV", "1, 3, calendar-access")
+
+	var kind davKind
+	name := ""
+	switch path := r.URL.Path; {
+	case path == davRoot:
+		kind = davKindRoot
+	case path == davCollection || path == strings.TrimSuffix(davCollection, "/"):
+		kind = davKindCollection
+	case strings.HasPrefix(path, davCollection) && !strings.Contains(path[len(davCollection):], "/"):
+		kind = davKindObject
+		name = path[len(davCollection):]
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodOptions:
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+		w.WriteHeader(http.StatusOK)
+	case r.Method == "PROPFIND":
+		s.propfind(w, r, kind, name)
+	case r.Method == "REPORT" && kind == davKindCollection:
+		s.report(w, r)
+	case (r.Method == http.MethodGet || r.Method == http.MethodHead) && kind == davKindObject:
+		s.getDAVObject(w, r, name)
+	case r.Method == http.MethodPut && kind == davKindObject:
+		s.putDAVObject(w, r, name)
+	case r.Method == http.MethodDelete && kind == davKindObject:
+		s.deleteDAVObject(w, r, name)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) propfind(w http.ResponseWriter, r *http.Request, kind davKind, name string) {
+	req, err := readDAVRequest(w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	depth := r.Header.Get("Depth") != "0"
+
+	var list []davResource
+	switch kind {
+	case davKindRoot:
+		list = append(list, davResource{kind: davKindRoot, href: davRoot})
+		if depth {
+			_, token, err := s.m.CalDAVObjects()
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			list = append(list, davResource{kind: davKindCollection, href: davCollection, token: token})
+		}
+	case davKindCollection:
+		objs, token, err := s.m.CalDAVObjects()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		list = append(list, davResource{kind: davKindCollection, href: davCollection, token: token})
+		if depth {
+			list = append(list, davObjects(objs)...)
+		}
+	case davKindObject:
+		obj, err := s.m.CalDAVObject(name)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		list = append(list, davObjects([]CalDAVObject{*obj})...)
+	}
+
+	ms := newMultistatus()
+	for _, res := range list {
+		ms.Responses = append(ms.Responses, davPropResponse(res, req))
+	}
+	writeMultistatus(w, ms)
+}
+
+// report answers calendar-query, calendar-multiget and sync-collection.
+// calendar-query only looks at the component filter, time ranges are not
+// applied and every todo is returned.
+func (s *Server) report(w http.ResponseWriter, r *http.Request) {
+	req, err := readDAVRequest(w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	ms := newMultistatus()
+	switch req.XMLName {
+	case xml.Name{Space: nsCalDAV, Local: "calendar-query"}:
+		objs, _, err := s.m.CalDAVObjects()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if req.Filter != nil && !req.Filter.Comp.matchesTodo() {
+			objs = nil
+		}
+		for _, res := range davObjects(objs) {
+			ms.Responses = append(ms.Responses, davPropResponse(res, req))
+		}
+
+	case xml.Name{Space: nsCalDAV, Local: "calendar-multiget"}:
+		for _, href := range req.Hrefs {
+			obj, err := s.davHref(href)
+			if errors.Is(err, ErrSearchTask) {
+				ms.Responses = append(ms.Responses, davResponse{Href: href, Status: davStatus(http.StatusNotFound)})
+				continue
+			}
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			ms.Responses = append(ms.Responses, davPropResponse(davObjects([]CalDAVObject{*obj})[0], req))
+		}
+
+	case xml.Name{Space: nsDAV, Local: "sync-collection"}:
+		var token int64
+		if req.SyncToken != "" {
+			v, ok := strings.CutPrefix(req.SyncToken, davSyncPrefix)
+			if token, err = strconv.ParseInt(v, 10, 64); !ok || err != nil {
+				writeDAVError(w, http.StatusForbidden, "valid-sync-token")
+				return
+			}
+		}
+		changed, deleted, current, err := s.m.CalDAVChanges(token)
+		if errors.Is(err, ErrSyncToken) {
+			writeDAVError(w, http.StatusForbidden, "valid-sync-token")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		for _, res := range davObjects(changed) {
+			ms.Responses = append(ms.Responses, davPropResponse(res, req))
+		}
+		for _, name := range deleted {
+			ms.Responses = append(ms.Responses, davResponse{Href: davObjectHref(name), Status: davStatus(http.StatusNotFound)})
+		}
+		ms.SyncToken = davSyncPrefix + strconv.FormatInt(current, 10)
+
+	default:
+		writeDAVError(w, http.StatusForbidden, "supported-report")
+		return
+	}
+	writeMultistatus(w, ms)
+}
+
+func (f *davCompFilter) matchesTodo() bool {
+	if len(f.Comps) == 0 {
+		return true
+	}
+	for _, c := range f.Comps {
+		if strings.EqualFold(c.Name, "VTODO") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) getDAVObject(w http.ResponseWriter, r *http.Request, name string) {
+	obj, err := s.m.CalDAVObject(name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("ETag", etag(obj.Task.Version))
+	w.Write(davCalendarData(obj))
+}
+
+func (s *Server) putDAVObject(w http.ResponseWriter, r *http.Request, name string) {
+	version, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if errors.As(err, new(*http.MaxBytesError)) {
+		writeError(w, r, ErrTooLarge)
+		return
+	}
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "bad_format", ErrBadFormat))
+		return
+	}
+	entries, err := parseICS(data)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var entry *importEntry
+	for i := range entries {
+		if entries[i].Type == "VTODO" {
+			entry = &entries[i]
+			break
+		}
+	}
+	if entry == nil {
+		writeError(w, r, ErrBadCalendar)
+		return
+	}
+
+	obj := &CalDAVObject{Name: name, UID: entry.UID, Task: entry.Task}
+	completed := errors.Is(entry.Err, ErrCompleted)
+	noOverwrite := r.Header.Get("If-None-Match") == "*"
+	created, err := s.m.PutCalDAVObject(obj, completed, version, noOverwrite)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// The ETag is only sent when the task is stored as uploaded, a moved
+	// date or a completed todo has to be fetched again (RFC 4791, 5.3.4).
+	stored, uploaded := obj.Task, entry.Task
+	if stored.Version > 0 && !completed && stored.Date == uploaded.Date && stored.Title == uploaded.Title &&
+		stored.Comment == uploaded.Comment && stored.Repeat == uploaded.Repeat {
+		w.Header().Set("ETag", etag(stored.Version))
+	}
+	if created {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deleteDAVObject(w http.ResponseWriter, r *http.Request, name string) {
+	version, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = s.m.DeleteCalDAVObject(name, version); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// davHref returns the object a multiget href points to.
+func (s *Server) davHref(href string) (*CalDAVObject, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return nil, ErrSearchTask
+	}
+	name, ok := strings.CutPrefix(u.Path, davCollection)
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return nil, ErrSearchTask
+	}
+	return s.m.CalDAVObject(name)
+}
+
+func readDAVRequest(w http.ResponseWriter, r *http.Request) (*davRequest, error) {
+	req := &davRequest{}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if errors.As(err, new(*http.MaxBytesError)) {
+		return nil, ErrTooLarge
+	}
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, "bad_format", ErrBadFormat)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return req, nil
+	}
+	if err = xml.Unmarshal(data, req); err != nil {
+		return nil, newAPIError(http.StatusBadRequest, "bad_format", ErrBadFormat)
+	}
+	return req, nil
+}
+
+func davObjects(objs []CalDAVObject) []davResource {
+	list := make([]davResource, len(objs))
+	for i := range objs {
+		list[i] = davResource{kind: davKindObject, href: davObjectHref(objs[i].Name), obj: &objs[i]}
+	}
+	return list
+}
+
+func davObjectHref(name string) string {
+	return davCollection + url.PathEscape(name)
+}
+
+// davPropResponse lists the requested properties of res, unknown ones are
+// reported with 404 in a second propstat.
+func davPropResponse(res davResource, req *davRequest) davResponse {
+	names := davAllProps
+	if req.Prop != nil {
+		names = make([]xml.Name, len(req.Prop.Names))
+		for i, n := range req.Prop.Names {
+			names[i] = n.XMLName
+		}
+	}
+
+	var found, missing davPropList
+	for _, name := range names {
+		value, ok := res.prop(name)
+		prop := davProp{XMLName: davName(name), Value: value}
+		if ok {
+			found.Props = append(found.Props, prop)
+		} else {
+			missing.Props = append(missing.Props, prop)
+		}
+	}
+
+	resp := davResponse{Href: res.href}
+	if len(found.Props) > 0 {
+		resp.Propstat = append(resp.Propstat, davPropstat{Prop: found, Status: davStatus(http.StatusOK)})
+	}
+	if len(missing.Props) > 0 {
+		resp.Propstat = append(resp.Propstat, davPropstat{Prop: missing, Status: davStatus(http.StatusNotFound)})
+	}
+	return resp
+}
+
+// prop returns the XML value of a property, ok is false when res has no
+// such property.
+func (res davResource) prop(name xml.Name) (string, bool) {
+	href := "<D:href>" + davRoot + "</D:href>"
+
+	switch name {
+	case xml.Name{Space: nsDAV, Local: "resourcetype"}:
+		switch res.kind {
+		case davKindRoot:
+			return "<D:collection/><D:principal/>", true
+		case davKindCollection:
+			return "<D:collection/><C:calendar/>", true
+		}
+		return "", true
+	case xml.Name{Space: nsDAV, Local: "current-user-principal"},
+		xml.Name{Space: nsDAV, Local: "principal-URL"},
+		xml.Name{Space: nsCalDAV, Local: "calendar-home-set"}:
+		return href, true
+	case xml.Name{Space: nsDAV, Local: "current-user-privilege-set"}:
+		return "<D:privilege><D:read/></D:privilege><D:privilege><D:write/></D:privilege>", true
+	}
+
+	switch res.kind {
+	case davKindRoot:
+		if name == (xml.Name{Space: nsDAV, Local: "displayname"}) {
+			return "Todo scheduler", true
+		}
+	case davKindCollection:
+		switch name {
+		case xml.Name{Space: nsDAV, Local: "displayname"}:
+			return "Задачи", true
+		case xml.Name{Space: nsCalDAV, Local: "supported-calendar-component-set"}:
+			return `<C:comp name="VTODO"/>`, true
+		case xml.Name{Space: nsDAV, Local: "supported-report-set"}:
+			return "<D:supported-report><D:report><C:calendar-query/></D:report></D:supported-report>" +
+				"<D:supported-report><D:report><C:calendar-multiget/></D:report></D:supported-report>" +
+				"<D:supported-report><D:report><D:sync-collection/></D:report></D:supported-report>", true
+		case xml.Name{Space: nsCS, Local: "getctag"}:
+			return strconv.FormatInt(res.token, 10), true
+		case xml.Name{Space: nsDAV, Local: "sync-token"}:
+			return davSyncPrefix + strconv.FormatInt(res.token, 10), true
+		}
+	case davKindObject:
+		switch name {
+		case xml.Name{Space: nsDAV, Local: "getetag"}:
+			return xmlText(etag(res.obj.Task.Version)), true
+		case xml.Name{Space: nsDAV, Local: "getcontenttype"}:
+			return "text/calendar; charset=utf-8; component=vtodo", true
+		case xml.Name{Space: nsCalDAV, Local: "calendar-data"}:
+			return xmlText(string(davCalendarData(res.obj))), true
+		}
+	}
+	return "", false
+}
+
+// davName writes known namespaces with the prefixes declared on the
+// multistatus element.
+func davName(name xml.Name) xml.Name {
+	if prefix, ok := davPrefixes[name.Space]; ok {
+		return xml.Name{Local: prefix + ":" + name.Local}
+	}
+	return name
+}
+
+func davCalendarData(obj *CalDAVObject) []byte {
+	var buf bytes.Buffer
+	icsBegin(&buf)
+	icsTask(&buf, &obj.Task, obj.UID, true, time.Now().UTC().Format("20060102T150405Z"))
+	icsLine(&buf, "END:VCALENDAR")
+	return buf.Bytes()
+}
+
+func davStatus(code int) string {
+	return "HTTP/1.1 " + strconv.Itoa(code) + " " + http.StatusText(code)
+}
+
+func xmlText(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func newMultistatus() *davMultistatus {
+	return &davMultistatus{DAV: nsDAV, CalDAV: nsCalDAV, CS: nsCS}
+}
+
+func writeMultistatus(w http.ResponseWriter, ms *davMultistatus) {
+	data, err := xml.Marshal(ms)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	w.Write(data)
+}
+
+// writeDAVError reports a failed WebDAV precondition such as valid-sync-token.
+func writeDAVError(w http.ResponseWriter, status int, condition string) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header+`<D:error xmlns:D="DAV:" xmlns:C="`+nsCalDAV+`"><D:`+condition+`/></D:error>`)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+func runCommand(name string, args []string) error {
+	switch name {
+	case "backup":
+		dest := fmt.Sprintf("scheduler-%s.db", time.Now().Format("20060102-150405"))
+		if len(args) > 0 {
+			dest = args[0]
+		}
+
+		db, err := NewStorage()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		if err = db.Backup(dest); err != nil {
+			return err
+		}
+		log.Println("Backup written to " + dest)
+		return nil
+
+	case "restore":
+		if len(args) == 0 {
+			return fmt.Errorf("usage: restore <backup file>")
+		}
+		if err := Restore(args[0]); err != nil {
+			return err
+		}
+		log.Println("Database restored from " + args[0] + ", previous file kept as " + dbName + ".bak")
+		return nil
+
+	case "rekey":
+		db, err := NewStorage()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		if db.cipher == nil {
+			return ErrBadKey
+		}
+		n, err := db.Rekey()
+		if err != nil {
+			return err
+		}
+		log.Printf("Re-encrypted %d rows with key %s", n, db.cipher.primary)
+		return nil
+
+	case "todotxt-export":
+		dest := "todo.txt"
+		if len(args) > 0 {
+			dest = args[0]
+		}
+
+		db, err := NewStorage()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		tasks, err := NewService(db).ExportTasks()
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		writeTodoTxt(&buf, tasks)
+		if err = os.WriteFile(dest, buf.Bytes(), 0644); err != nil {
+			return err
+		}
+		log.Printf("Exported %d tasks to %s", len(tasks), dest)
+		return nil
+
+	case "todotxt-import":
+		if len(args) == 0 {
+			return fmt.Errorf("usage: todotxt-import <file> [skip|overwrite|duplicate]")
+		}
+		conflict := ConflictSkip
+		if len(args) > 1 {
+			conflict = args[1]
+		}
+		if conflict != ConflictSkip && conflict != ConflictOverwrite && conflict != ConflictDuplicate {
+			return fmt.Errorf("unknown conflict mode %q, expected skip, overwrite or duplicate", conflict)
+		}
+
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+
+		db, err := NewStorage()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var tasks []Task
+		entries := parseTodoTxt(data, time.Now())
+		for _, e := range entries {
+			if e.Err != nil {
+				log.Printf("Skipped %q: %v", e.Task.Title, e.Err)
+				continue
+			}
+			tasks = append(tasks, e.Task)
+		}
+
+		svc := NewService(db)
+		keepComments(svc, tasks)
+		results, err := svc.RestoreTasks(tasks, conflict, false)
+		if err != nil {
+			return err
+		}
+
+		imported := 0
+		for i, res := range results {
+			if res.Err != nil {
+				log.Printf("Skipped %q: %v", tasks[i].Title, res.Err)
+				continue
+			}
+			imported++
+		}
+		log.Printf("Imported %d of %d tasks from %s", imported, len(entries), args[0])
+		return nil
+
+	default:
+		return fmt.Errorf("unknown command %q, expected backup, restore, rekey, todotxt-export or todotxt-import", name)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"strings"
+)
+
+const (
+	encPrefix   = "enc:"
+	plainPrefix = "plain:"
+
+	// sizes of the standard GCM nonce and tag
+	nonceSize = 12
+	tagSize   = 16
+)
+
+// Cipher encrypts task content with AES-256-GCM. Values are stored as
+// "enc:<key id>:<base64(nonce|ciphertext)>", so old keys stay usable for
+// reading after a new primary key is added. A nil Cipher stores plain text,
+// plain values that look like ciphertext are stored with the "plain:" prefix.
+type Cipher struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// LoadCipher reads keys from TODO_ENCRYPTION_KEY or the file named by
+// TODO_ENCRYPTION_KEY_FILE. Keys are "id:base64" separated by commas or new
+// lines, the first one is used for encryption. A single key may omit the id.
+func LoadCipher() (*Cipher, error) {
+	spec := os.Getenv("TODO_ENCRYPTION_KEY")
+	if fname := os.Getenv("TODO_ENCRYPTION_KEY_FILE"); fname != "" {
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			return nil, err
+		}
+		spec = string(data)
+	}
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	return NewCipher(spec)
+}
+
+func NewCipher(spec string) (*Cipher, error) {
+	c := &Cipher{keys: map[string]cipher.AEAD{}}
+
+	fields := strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	})
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" || strings.HasPrefix(field, "#") {
+			continue
+		}
+
+		id, encoded, found := strings.Cut(field, ":")
+		if !found {
+			id, encoded = "1", field
+		}
+		if id == "" || strings.Contains(id, ":") {
+			return nil, ErrBadKey
+		}
+		if _, ok := c.keys[id]; ok {
+			return nil, ErrBadKey
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, ErrBadKey
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		c.keys[id] = aead
+		if c.primary == "" {
+			c.primary = id
+		}
+	}
+
+	if c.primary == "" {
+		return nil, ErrBadKey
+	}
+	return c, nil
+}
+
+// Encrypt seals value with the primary key. The column name is bound as
+// additional data so a title can't be passed off as a comment.
+func (c *Cipher) Encrypt(column, value string) (string, error) {
+	if c == nil || value == "" {
+		if strings.HasPrefix(value, encPrefix) || strings.HasPrefix(value, plainPrefix) {
+			return plainPrefix + value, nil
+		}
+		return value, nil
+	}
+
+	aead := c.keys[c.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(column))
+
+	return encPrefix + c.primary + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value produced by Encrypt. Ciphertext can't be read
+// without a Cipher and fails with ErrNoKey, so it is never shown or written
+// back as plain text. Values written before plain ones were escaped are
+// plain text when they don't parse as ciphertext.
+func (c *Cipher) Decrypt(column, value string) (string, error) {
+	if plain, ok := strings.CutPrefix(value, plainPrefix); ok {
+		return plain, nil
+	}
+	id, sealed, ok := parseSealed(value)
+	if !ok {
+		return value, nil
+	}
+	if c == nil {
+		return "", ErrNoKey
+	}
+	aead, ok := c.keys[id]
+	if !ok {
+		return "", ErrNoKey
+	}
+	nonce, data := sealed[:nonceSize], sealed[nonceSize:]
+
+	plain, err := aead.Open(nil, nonce, data, []byte(column))
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(plain), nil
+}
+
+// parseSealed splits a value produced by Encrypt into the key id and the
+// nonce with the ciphertext.
+func parseSealed(value string) (string, []byte, bool) {
+	rest, ok := strings.CutPrefix(value, encPrefix)
+	if !ok {
+		return "", nil, false
+	}
+	id, encoded, found := strings.Cut(rest, ":")
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if !found || id == "" || err != nil || len(sealed) < nonceSize+tagSize {
+		return "", nil, false
+	}
+	return id, sealed, true
+}
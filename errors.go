@@ -9,18 +9,50 @@ var (
 	ErrBadFormat  = fmt.Errorf("некорректный формат")
 	ErrBadVal     = fmt.Errorf("некорректное значение")
 	ErrBadTask    = fmt.Errorf("некорректная задача")
-	ErrSearchTask = fmt.Errorf("задача не найдена")
+	ErrSearchTask = fmt.Errorf("задача не найдена")
+	ErrSearchRev  = fmt.Errorf("версия задачи не найдена")
 	ErrRows       = fmt.Errorf("изменено 0 строк")
+	ErrVersion    = fmt.Errorf("задача была изменена другим пользователем")
+	ErrNoIfMatch  = fmt.Errorf("не указан заголовок If-Match")
+	ErrIdemReused = fmt.Errorf("ключ идемпотентности уже использован для другого запроса")
+	ErrIdemBusy   = fmt.Errorf("запрос с этим ключом идемпотентности ещё выполняется")
 
 	ErrOpenDB    = fmt.Errorf("не удалось открыть базу данных")
 	ErrCreateDB  = fmt.Errorf("не удалось создать базу данных")
 	ErrSqlExec   = fmt.Errorf("не удалось выполнить запрос")
 	ErrCreateIdx = fmt.Errorf("не удалось создать индекс")
 	ErrMigrateDb = fmt.Errorf("не удалось выполнить миграцию бд")
+	ErrBadBackup = fmt.Errorf("файл не является резервной копией планировщика")
+	ErrSchemaVer = fmt.Errorf("неподдерживаемая версия схемы базы данных")
+	ErrBadKey    = fmt.Errorf("некорректный ключ шифрования")
+	ErrNoKey     = fmt.Errorf("нет ключа для расшифровки данных")
+	ErrDecrypt   = fmt.Errorf("не удалось расшифровать данные")
 
 	ErrEmptyTitle = fmt.Errorf("заголовок задачи не может быть пустым")
-	ErrEmptyDate  = fmt.Errorf("дата задачи не может быть пустой")
+	ErrEmptyDate  = fmt.Errorf("дата задачи не может быть пустой")
 	ErrSearch     = fmt.Errorf("ошибка в поиске")
 	ErrEmptyId    = fmt.Errorf("не указан id")
-	ErrId         = fmt.Errorf("некорректный id")
+	ErrId         = fmt.Errorf("некорректный id")
+	ErrBatchOp    = fmt.Errorf("некорректная операция")
+	ErrBatchSize  = fmt.Errorf("слишком много операций в одном запросе")
+	ErrSchema     = fmt.Errorf("запрос не соответствует схеме")
+
+	ErrForbidden = fmt.Errorf("доступ запрещён")
+	ErrLocked    = fmt.Errorf("задачу уже редактирует другой пользователь")
+
+	ErrSearchHook     = fmt.Errorf("вебхук не найден")
+	ErrSearchDelivery = fmt.Errorf("доставка не найдена")
+	ErrHookURL        = fmt.Errorf("адрес должен начинаться с http:// или https://")
+	ErrHookEvent      = fmt.Errorf("неизвестное событие")
+
+	ErrSearchCalToken = fmt.Errorf("токен календаря не найден")
+	ErrEmptyName      = fmt.Errorf("не указано имя")
+
+	ErrBadCalendar = fmt.Errorf("файл не является календарём iCalendar")
+	ErrTooLarge    = fmt.Errorf("файл слишком большой")
+	ErrCompleted   = fmt.Errorf("задача уже выполнена или отменена")
+	ErrPastEvent   = fmt.Errorf("событие уже прошло")
+	ErrSyncToken   = fmt.Errorf("недействительный sync-token")
+	ErrBadImport   = fmt.Errorf("не удалось прочитать файл")
+	ErrTaskExists  = fmt.Errorf("задача с таким id уже есть")
 )
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDone    = "done"
+	EventDeleted = "deleted"
+	EventOverdue = "overdue"
+)
+
+// Event describes a committed change of a task. Task is the state after the
+// change; for deleted tasks and one-off tasks marked done it is the last
+// state before removal and Deleted is set.
+type Event struct {
+	ID      uint64 `json:"-"`
+	Type    string `json:"type"`
+	Task    Task   `json:"task"`
+	Version int64  `json:"version"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+const (
+	subscriberBuffer = 64
+	heartbeatEvery   = 15 * time.Second
+)
+
+// Broker fans events out to subscribers. A subscriber that falls behind by
+// more than subscriberBuffer events is dropped, its channel is closed.
+type Broker struct {
+	mu     sync.Mutex
+	lastID uint64
+	subs   map[chan Event]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[chan Event]struct{})}
+}
+
+func (b *Broker) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *Broker) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	e.ID = b.lastID
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// events streams task changes as Server-Sent Events. Events published
+// while a client is disconnected are not replayed, clients should reload
+// the list after reconnecting.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, errors.New("response writer does not support flushing"))
+		return
+	}
+
+	ch, unsubscribe := s.m.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatEvery)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				log.Printf("Failed to encode event: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// exportFormats maps the format parameter of export and import to the
+// content type of the file.
+var exportFormats = map[string]string{
+	"csv":     "text/csv; charset=utf-8",
+	"json":    "application/json; charset=utf-8",
+	"jsonl":   "application/x-ndjson; charset=utf-8",
+	"todotxt": "text/plain; charset=utf-8",
+}
+
+var utf8BOM = []byte("\ufeff")
+
+// exportTasks writes every task as a CSV, JSON, JSON Lines or todo.txt
+// file that /api/import reads back.
+func (s *Server) exportTasks(w http.ResponseWriter, r *http.Request) {
+	format, err := formatParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tasks, err := s.m.ExportTasks()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "csv":
+		err = writeCSV(&buf, tasks)
+	case "json":
+		if tasks == nil {
+			tasks = []Task{}
+		}
+		err = json.NewEncoder(&buf).Encode(TaskList{Tasks: tasks})
+	case "jsonl":
+		enc := json.NewEncoder(&buf)
+		for i := 0; i < len(tasks) && err == nil; i++ {
+			err = enc.Encode(tasks[i])
+		}
+	case "todotxt":
+		writeTodoTxt(&buf, tasks)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", exportFormats[format])
+	name := "tasks." + format
+	if format == "todotxt" {
+		name = "todo.txt"
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Write(buf.Bytes())
+}
+
+// importTasks reads a file written by exportTasks. Tasks are matched by id,
+// the conflict parameter decides what happens to the ones already stored.
+func (s *Server) importTasks(w http.ResponseWriter, r *http.Request) {
+	format, err := formatParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	conflict := r.URL.Query().Get("conflict")
+	switch conflict {
+	case "":
+		conflict = ConflictSkip
+	case ConflictSkip, ConflictOverwrite, ConflictDuplicate:
+	default:
+		writeError(w, r, &APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: "conflict", Err: ErrBadFormat})
+		return
+	}
+	dryRun, err := dryRunParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	data, err := readUpload(w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var entries []importEntry
+	switch format {
+	case "csv":
+		entries, err = parseCSV(data)
+	case "json":
+		entries, err = parseJSONTasks(data)
+	case "jsonl":
+		entries = parseJSONLines(data)
+	case "todotxt":
+		entries = parseTodoTxt(data, time.Now())
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.runImport(w, r, entries, dryRun, func(tasks []Task) ([]BatchResult, error) {
+		if format == "todotxt" {
+			keepComments(s.m, tasks)
+		}
+		return s.m.RestoreTasks(tasks, conflict, dryRun)
+	})
+}
+
+func formatParam(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		return "json", nil
+	}
+	if _, ok := exportFormats[format]; !ok {
+		return "", &APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: "format", Err: ErrBadFormat}
+	}
+	return format, nil
+}
+
+// taskColumns are the JSON names of the Task fields, so that fields added
+// later are exported and imported as well.
+func taskColumns() []string {
+	var columns []string
+	typ := reflect.TypeOf(Task{})
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			columns = append(columns, name)
+		}
+	}
+	return columns
+}
+
+// writeCSV writes a header with taskColumns and a row per task. The file
+// starts with a BOM, without it spreadsheets don't recognize UTF-8.
+func writeCSV(buf *bytes.Buffer, tasks []Task) error {
+	columns := taskColumns()
+	buf.Write(utf8BOM)
+	cw := csv.NewWriter(buf)
+	cw.Write(columns)
+	for _, t := range tasks {
+		fields, err := taskFields(t)
+		if err != nil {
+			return err
+		}
+		row := make([]string, len(columns))
+		for i, c := range columns {
+			if v, ok := fields[c]; ok && v != nil {
+				row[i] = fmt.Sprint(v)
+			}
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func taskFields(t Task) (map[string]any, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// parseCSV reads a file with a header row. Columns that are not task
+// fields are reported as unmapped.
+func parseCSV(data []byte) ([]importEntry, error) {
+	cr := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, utf8BOM)))
+	records, err := cr.ReadAll()
+	if err != nil || len(records) == 0 {
+		return nil, ErrBadImport
+	}
+
+	columns := taskColumns()
+	header := records[0]
+	var unmapped []string
+	known := false
+	for _, name := range header {
+		if slices.Contains(columns, name) {
+			known = true
+		} else {
+			unmapped = append(unmapped, name)
+		}
+	}
+	if !known {
+		return nil, ErrBadImport
+	}
+
+	entries := make([]importEntry, 0, len(records)-1)
+	for _, record := range records[1:] {
+		obj := make(map[string]json.RawMessage)
+		for i, name := range header {
+			if slices.Contains(columns, name) {
+				obj[name], _ = json.Marshal(record[i])
+			}
+		}
+		entries = append(entries, taskEntry(obj))
+		entries[len(entries)-1].Unmapped = unmapped
+	}
+	return entries, nil
+}
+
+// parseJSONTasks reads an array of tasks or an object with a tasks array,
+// the format of GET /api/tasks.
+func parseJSONTasks(data []byte) ([]importEntry, error) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err != nil {
+		var tl struct {
+			Tasks []json.RawMessage `json:"tasks"`
+		}
+		if err = json.Unmarshal(data, &tl); err != nil || tl.Tasks == nil {
+			return nil, ErrBadImport
+		}
+		list = tl.Tasks
+	}
+
+	columns := taskColumns()
+	entries := make([]importEntry, len(list))
+	for i, raw := range list {
+		entries[i] = jsonEntry(raw, columns)
+	}
+	return entries, nil
+}
+
+// parseJSONLines reads a task per line, a broken line only fails its entry.
+func parseJSONLines(data []byte) []importEntry {
+	columns := taskColumns()
+	var entries []importEntry
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, maxImportSize)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		entries = append(entries, jsonEntry(line, columns))
+	}
+	return entries
+}
+
+func jsonEntry(raw []byte, columns []string) importEntry {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil || obj == nil {
+		return importEntry{Err: ErrBadTask}
+	}
+	entry := taskEntry(obj)
+	for name := range obj {
+		if !slices.Contains(columns, name) {
+			entry.Unmapped = append(entry.Unmapped, name)
+		}
+	}
+	slices.Sort(entry.Unmapped)
+	return entry
+}
+
+func taskEntry(obj map[string]json.RawMessage) importEntry {
+	var entry importEntry
+	data, err := json.Marshal(obj)
+	if err == nil {
+		err = json.Unmarshal(data, &entry.Task)
+	}
+	if err != nil {
+		entry.Err = ErrBadTask
+	}
+	return entry
+}
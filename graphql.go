@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+//go:embed api/schema.graphql
+var graphqlSchema string
+
+const (
+	maxPageSize     = 100
+	maxGraphQLDepth = 8
+)
+
+type langKey struct{}
+
+// gqlError carries the same code, fields and details as the HTTP error body
+// in the GraphQL error extensions.
+type gqlError struct {
+	message    string
+	extensions map[string]any
+}
+
+func (e *gqlError) Error() string {
+	return e.message
+}
+
+func (e *gqlError) Extensions() map[string]any {
+	return e.extensions
+}
+
+func toGQLError(ctx context.Context, err error) error {
+	e := toAPIError(err)
+	if e.Status >= http.StatusInternalServerError {
+		log.Printf("GraphQL request failed: %v", err)
+	}
+
+	lang, _ := ctx.Value(langKey{}).(string)
+	msg := message(lang, e.Code, e.Err)
+
+	ext := map[string]any{"code": e.Code}
+	if e.Field != "" {
+		fieldMsg := msg
+		if e.Hint != "" {
+			fieldMsg = message(lang, e.Hint, e.Err)
+		}
+		ext["fields"] = map[string]string{e.Field: fieldMsg}
+	}
+	if e.Details != nil {
+		ext["details"] = e.Details
+	}
+	return &gqlError{message: msg, extensions: ext}
+}
+
+func (s *Server) graphql() http.HandlerFunc {
+	schema := graphql.MustParseSchema(graphqlSchema, &gqlResolver{m: s.m},
+		graphql.MaxDepth(maxGraphQLDepth), graphql.UseStringDescriptions())
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Query         string         `json:"query"`
+			OperationName string         `json:"operationName"`
+			Variables     map[string]any `json:"variables"`
+		}
+
+		if err := decodeJSON(r, &req); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), langKey{}, language(r))
+		writeJSON(w, http.StatusOK, schema.Exec(ctx, req.Query, req.OperationName, req.Variables))
+	}
+}
+
+type gqlResolver struct {
+	m TodoList
+}
+
+func (q *gqlResolver) Task(ctx context.Context, args struct{ ID graphql.ID }) (*taskResolver, error) {
+	t, err := q.m.GetTask(string(args.ID))
+	if errors.Is(err, ErrSearchTask) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	return &taskResolver{m: q.m, t: t}, nil
+}
+
+type taskFilterInput struct {
+	From      *string
+	To        *string
+	Search    *string
+	Repeating *bool
+}
+
+func (q *gqlResolver) Tasks(ctx context.Context, args struct {
+	Filter *taskFilterInput
+	Limit  int32
+	Offset int32
+}) (*taskPageResolver, error) {
+	if args.Limit <= 0 || args.Limit > maxPageSize {
+		return nil, toGQLError(ctx, &APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: "limit", Err: ErrBadFormat})
+	}
+	if args.Offset < 0 {
+		return nil, toGQLError(ctx, &APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: "offset", Err: ErrBadFormat})
+	}
+
+	f := TaskFilter{Limit: int(args.Limit), Offset: int(args.Offset)}
+	if args.Filter != nil {
+		f.From = deref(args.Filter.From)
+		f.To = deref(args.Filter.To)
+		f.Search = deref(args.Filter.Search)
+		f.Repeating = args.Filter.Repeating
+	}
+
+	tl, total, err := q.m.FindTasks(f)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	return &taskPageResolver{m: q.m, tl: tl, total: total}, nil
+}
+
+func (q *gqlResolver) NextDate(ctx context.Context, args struct {
+	Now    *string
+	Date   string
+	Repeat string
+}) (string, error) {
+	now := deref(args.Now)
+	if now == "" {
+		now = time.Now().Format("20060102")
+	}
+
+	nowTime, err := time.Parse("20060102", now)
+	if err != nil {
+		return "", toGQLError(ctx, &APIError{Status: http.StatusBadRequest, Code: "invalid_date", Field: "now", Err: ErrBadDate})
+	}
+
+	next, err := q.m.NextDate(nowTime, args.Date, args.Repeat)
+	if err != nil {
+		return "", toGQLError(ctx, err)
+	}
+	return next, nil
+}
+
+type taskInput struct {
+	Date    *string
+	Title   string
+	Comment *string
+	Repeat  *string
+}
+
+func (in *taskInput) task(id string) *Task {
+	return &Task{
+		ID:      id,
+		Date:    deref(in.Date),
+		Title:   in.Title,
+		Comment: deref(in.Comment),
+		Repeat:  deref(in.Repeat),
+	}
+}
+
+func (q *gqlResolver) AddTask(ctx context.Context, args struct{ Input taskInput }) (*taskResolver, error) {
+	t, err := q.m.ValidTaskAndModify(args.Input.task(""))
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+
+	id, err := q.m.AddTask(t)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+
+	t, err = q.m.GetTask(id)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	return &taskResolver{m: q.m, t: t}, nil
+}
+
+func (q *gqlResolver) UpdateTask(ctx context.Context, args struct {
+	ID      graphql.ID
+	Input   taskInput
+	Version *int32
+}) (*taskResolver, error) {
+	t, err := q.m.ValidTaskAndModify(args.Input.task(string(args.ID)))
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+
+	t.Version = int64(deref(args.Version))
+	if err = q.m.UpdateTask(t); err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	return &taskResolver{m: q.m, t: t}, nil
+}
+
+func (q *gqlResolver) DoneTask(ctx context.Context, args struct {
+	ID      graphql.ID
+	Version *int32
+}) (*taskResolver, error) {
+	t, err := q.m.DoneTask(string(args.ID), int64(deref(args.Version)))
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	if t == nil {
+		return nil, nil
+	}
+	return &taskResolver{m: q.m, t: t}, nil
+}
+
+func (q *gqlResolver) DeleteTask(ctx context.Context, args struct {
+	ID      graphql.ID
+	Version *int32
+}) (bool, error) {
+	if err := q.m.DeleteTask(string(args.ID), int64(deref(args.Version))); err != nil {
+		return false, toGQLError(ctx, err)
+	}
+	return true, nil
+}
+
+type taskPageResolver struct {
+	m     TodoList
+	tl    *TaskList
+	total int
+}
+
+func (p *taskPageResolver) Total() int32 {
+	return int32(p.total)
+}
+
+func (p *taskPageResolver) Tasks() []*taskResolver {
+	tasks := make([]*taskResolver, len(p.tl.Tasks))
+	for i := range p.tl.Tasks {
+		tasks[i] = &taskResolver{m: p.m, t: &p.tl.Tasks[i]}
+	}
+	return tasks
+}
+
+type taskResolver struct {
+	m TodoList
+	t *Task
+}
+
+func (r *taskResolver) ID() graphql.ID  { return graphql.ID(r.t.ID) }
+func (r *taskResolver) Date() string    { return r.t.Date }
+func (r *taskResolver) Title() string   { return r.t.Title }
+func (r *taskResolver) Comment() string { return r.t.Comment }
+func (r *taskResolver) Repeat() string  { return r.t.Repeat }
+func (r *taskResolver) Version() int32  { return int32(r.t.Version) }
+
+func (r *taskResolver) Rule() *ruleResolver {
+	if r.t.Repeat == "" {
+		return nil
+	}
+	rule, err := ParseRepeat(r.t.Repeat)
+	if err != nil {
+		return nil
+	}
+	return &ruleResolver{rule}
+}
+
+func (r *taskResolver) Occurrences(ctx context.Context, args struct{ Count int32 }) ([]string, error) {
+	if args.Count <= 0 || args.Count > maxPageSize {
+		return nil, toGQLError(ctx, &APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: "count", Err: ErrBadFormat})
+	}
+
+	dates, err := r.m.Occurrences(r.t, int(args.Count))
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	return dates, nil
+}
+
+func (r *taskResolver) History(ctx context.Context) ([]*revisionResolver, error) {
+	rl, err := r.m.GetRevisions(r.t.ID)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+
+	revs := make([]*revisionResolver, len(rl.Revisions))
+	for i := range rl.Revisions {
+		revs[i] = &revisionResolver{&rl.Revisions[i]}
+	}
+	return revs, nil
+}
+
+type ruleResolver struct {
+	r *Rule
+}
+
+func (r *ruleResolver) Type() string { return r.r.Type }
+
+func (r *ruleResolver) Days() *int32 {
+	if r.r.Days == 0 {
+		return nil
+	}
+	days := int32(r.r.Days)
+	return &days
+}
+
+type revisionResolver struct {
+	r *Revision
+}
+
+func (r *revisionResolver) ID() graphql.ID    { return graphql.ID(r.r.ID) }
+func (r *revisionResolver) Date() string      { return r.r.Date }
+func (r *revisionResolver) Title() string     { return r.r.Title }
+func (r *revisionResolver) Comment() string   { return r.r.Comment }
+func (r *revisionResolver) Repeat() string    { return r.r.Repeat }
+func (r *revisionResolver) Version() int32    { return int32(r.r.Version) }
+func (r *revisionResolver) CreatedAt() string { return r.r.CreatedAt }
+
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
@@ -0,0 +1,268 @@
+package main
+
+//go:generate protoc -I api --go_out=api/todopb --go_opt=paths=source_relative --go-grpc_out=api/todopb --go-grpc_opt=paths=source_relative todo.proto
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"go_final_project/api/todopb"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCServer exposes the same TodoList as Server over gRPC, see api/todo.proto.
+type GRPCServer struct {
+	todopb.UnimplementedTodoServer
+
+	m          TodoList
+	adminToken string
+}
+
+func NewGRPCServer(td TodoList) *GRPCServer {
+	return &GRPCServer{
+		m:          td,
+		adminToken: os.Getenv("TODO_ADMIN_TOKEN"),
+	}
+}
+
+func (g *GRPCServer) Start(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(g.unaryAuth),
+		grpc.StreamInterceptor(g.streamAuth),
+	)
+	todopb.RegisterTodoServer(srv, g)
+
+	log.Println("gRPC listening on " + addr)
+	return srv.Serve(lis)
+}
+
+// Like the admin HTTP routes, every call needs the admin token and gRPC is
+// unavailable while it is not configured.
+func (g *GRPCServer) auth(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, h := range md.Get("authorization") {
+		if authorized(g.adminToken, h) {
+			return nil
+		}
+	}
+	return grpcError(ctx, ErrForbidden)
+}
+
+func (g *GRPCServer) unaryAuth(ctx context.Context, req any, _ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (any, error) {
+	if err := g.auth(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (g *GRPCServer) streamAuth(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	if err := g.auth(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusConflict:              codes.Aborted,
+	http.StatusPreconditionFailed:    codes.FailedPrecondition,
+	http.StatusPreconditionRequired:  codes.FailedPrecondition,
+	http.StatusRequestEntityTooLarge: codes.InvalidArgument,
+	http.StatusUnprocessableEntity:   codes.InvalidArgument,
+}
+
+// grpcError converts err like writeError does, the HTTP API code goes into
+// an ErrorInfo detail so clients don't have to parse the message.
+func grpcError(ctx context.Context, err error) error {
+	e := toAPIError(err)
+	if e.Status >= http.StatusInternalServerError {
+		log.Printf("gRPC call failed: %v", err)
+	}
+
+	code, ok := grpcCodes[e.Status]
+	if !ok {
+		code = codes.Internal
+	}
+
+	lang := defaultLang
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("accept-language"); len(v) > 0 {
+			lang = negotiate(v[0])
+		}
+	}
+
+	info := &errdetails.ErrorInfo{Reason: e.Code, Domain: "todo", Metadata: map[string]string{}}
+	for k, v := range e.Details {
+		info.Metadata[k] = v
+	}
+	if e.Field != "" {
+		info.Metadata["field"] = e.Field
+	}
+
+	st, detErr := status.New(code, message(lang, e.Code, e.Err)).WithDetails(info)
+	if detErr != nil {
+		return status.Error(code, message(lang, e.Code, e.Err))
+	}
+	return st.Err()
+}
+
+func toTaskPB(t *Task) *todopb.Task {
+	id, _ := strconv.ParseInt(t.ID, 10, 64)
+	return &todopb.Task{
+		Id:      id,
+		Date:    t.Date,
+		Title:   t.Title,
+		Comment: t.Comment,
+		Repeat:  t.Repeat,
+		Version: t.Version,
+	}
+}
+
+func fromTaskPB(t *todopb.Task) *Task {
+	if t == nil {
+		return &Task{}
+	}
+	task := &Task{Date: t.Date, Title: t.Title, Comment: t.Comment, Repeat: t.Repeat}
+	if t.Id != 0 {
+		task.ID = strconv.FormatInt(t.Id, 10)
+	}
+	return task
+}
+
+func grpcId(id int64) (string, error) {
+	if id <= 0 {
+		return "", ErrId
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+func (g *GRPCServer) ListTasks(_ *todopb.ListTasksRequest, stream todopb.Todo_ListTasksServer) error {
+	tl, err := g.m.GetTasks()
+	if err != nil {
+		return grpcError(stream.Context(), err)
+	}
+
+	for i := range tl.Tasks {
+		if err = stream.Send(toTaskPB(&tl.Tasks[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *GRPCServer) GetTask(ctx context.Context, req *todopb.GetTaskRequest) (*todopb.Task, error) {
+	id, err := grpcId(req.Id)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	t, err := g.m.GetTask(id)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return toTaskPB(t), nil
+}
+
+func (g *GRPCServer) CreateTask(ctx context.Context, req *todopb.CreateTaskRequest) (*todopb.Task, error) {
+	t := fromTaskPB(req.Task)
+	t.ID = ""
+
+	t, err := g.m.ValidTaskAndModify(t)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	id, err := g.m.AddTask(t)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	t, err = g.m.GetTask(id)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return toTaskPB(t), nil
+}
+
+func (g *GRPCServer) UpdateTask(ctx context.Context, req *todopb.UpdateTaskRequest) (*todopb.Task, error) {
+	if _, err := grpcId(req.GetTask().GetId()); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	t, err := g.m.ValidTaskAndModify(fromTaskPB(req.Task))
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	t.Version = req.Version
+	if err = g.m.UpdateTask(t); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return toTaskPB(t), nil
+}
+
+func (g *GRPCServer) DeleteTask(ctx context.Context, req *todopb.DeleteTaskRequest) (*todopb.DeleteTaskResponse, error) {
+	id, err := grpcId(req.Id)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	if err = g.m.DeleteTask(id, req.Version); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &todopb.DeleteTaskResponse{}, nil
+}
+
+func (g *GRPCServer) DoneTask(ctx context.Context, req *todopb.DoneTaskRequest) (*todopb.DoneTaskResponse, error) {
+	id, err := grpcId(req.Id)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	t, err := g.m.DoneTask(id, req.Version)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	res := &todopb.DoneTaskResponse{}
+	if t != nil {
+		res.Task = toTaskPB(t)
+	}
+	return res, nil
+}
+
+func (g *GRPCServer) NextDate(ctx context.Context, req *todopb.NextDateRequest) (*todopb.NextDateResponse, error) {
+	if req.Now == "" {
+		req.Now = time.Now().Format("20060102")
+	}
+
+	now, err := time.Parse("20060102", req.Now)
+	if err != nil {
+		return nil, grpcError(ctx, &APIError{Status: http.StatusBadRequest, Code: "invalid_date", Field: "now", Err: ErrBadDate})
+	}
+
+	next, err := g.m.NextDate(now, req.Date, req.Repeat)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &todopb.NextDateResponse{NextDate: next}, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultLang = "ru"
+
+// messages holds the error texts by language and error code. The Russian
+// texts of errors are the Err* values themselves, only the hints that have
+// no error of their own are written here.
+var messages = map[string]map[string]string{
+	"ru": {
+		"id_required":        ErrEmptyId.Error(),
+		"invalid_id":         ErrId.Error(),
+		"bad_format":         ErrBadFormat.Error(),
+		"invalid_json":       ErrBadFormat.Error(),
+		"forbidden":          ErrForbidden.Error(),
+		"task_not_found":     ErrSearchTask.Error(),
+		"revision_not_found": ErrSearchRev.Error(),
+		"webhook_not_found":  ErrSearchHook.Error(),
+		"delivery_not_found": ErrSearchDelivery.Error(),
+		"invalid_url":        ErrHookURL.Error(),
+		"invalid_event":      ErrHookEvent.Error(),
+		"name_required":      ErrEmptyName.Error(),
+		"invalid_calendar":   ErrBadCalendar.Error(),
+		"file_too_large":     ErrTooLarge.Error(),
+		"completed":          ErrCompleted.Error(),
+		"past_event":         ErrPastEvent.Error(),
+		"invalid_sync_token": ErrSyncToken.Error(),
+		"invalid_file":       ErrBadImport.Error(),
+		"task_exists":        ErrTaskExists.Error(),
+		"version_mismatch":   ErrVersion.Error(),
+		"if_match_required":  ErrNoIfMatch.Error(),
+		"title_required":     ErrEmptyTitle.Error(),
+		"invalid_date":       ErrBadDate.Error(),
+		"invalid_repeat":     ErrBadVal.Error(),
+		"invalid_task":       ErrBadTask.Error(),
+		"internal":           ErrSqlExec.Error(),
+		"date_required":      ErrEmptyDate.Error(),
+		"invalid_operation":  ErrBatchOp.Error(),
+		"batch_too_large":    ErrBatchSize.Error(),
+		"task_locked":        ErrLocked.Error(),
+
+		"idempotency_key_reused":      ErrIdemReused.Error(),
+		"idempotency_key_in_progress": ErrIdemBusy.Error(),
+		"calendar_token_not_found":    ErrSearchCalToken.Error(),
+
+		"repeat_empty":        "правило повторения не указано",
+		"repeat_unknown_type": "правило должно начинаться с d или y",
+		"repeat_missing":      "для правила d нужно указать число дней",
+		"repeat_not_a_number": "число дней должно быть целым числом",
+		"repeat_out_of_range": "число дней должно быть от 1 до 400",
+		"repeat_unexpected":   "лишние значения в правиле",
+
+		"schema_violation":  ErrSchema.Error(),
+		"schema_type":       "неверный тип значения",
+		"schema_required":   "обязательное поле",
+		"schema_additional": "неизвестное поле",
+		"schema_enum":       "недопустимое значение",
+		"schema_pattern":    "значение не соответствует формату",
+	},
+	"en": {
+		"id_required":        "id is required",
+		"invalid_id":         "invalid id",
+		"bad_format":         "invalid format",
+		"invalid_json":       "request body is not valid JSON",
+		"forbidden":          "access denied",
+		"task_not_found":     "task not found",
+		"revision_not_found": "task revision not found",
+		"webhook_not_found":  "webhook not found",
+		"delivery_not_found": "delivery not found",
+		"invalid_url":        "the URL must start with http:// or https://",
+		"invalid_event":      "unknown event",
+		"name_required":      "name is required",
+		"invalid_calendar":   "the file is not an iCalendar file",
+		"file_too_large":     "the file is too large",
+		"completed":          "the task is already completed or cancelled",
+		"past_event":         "the event is in the past",
+		"invalid_sync_token": "invalid sync token",
+		"invalid_file":       "the file could not be read",
+		"task_exists":        "a task with this id already exists",
+		"version_mismatch":   "the task was changed by someone else",
+		"if_match_required":  "If-Match header is required",
+		"title_required":     "task title must not be empty",
+		"invalid_date":       "invalid date",
+		"invalid_repeat":     "invalid repeat rule",
+		"invalid_task":       "invalid task",
+		"internal":           "failed to process the request",
+		"date_required":      "task date must not be empty",
+		"invalid_operation":  "unknown operation",
+		"batch_too_large":    "too many operations in one request",
+		"task_locked":        "the task is being edited by someone else",
+
+		"idempotency_key_reused":      "idempotency key was already used for a different request",
+		"idempotency_key_in_progress": "a request with this idempotency key is still in progress",
+		"calendar_token_not_found":    "calendar token not found",
+
+		"repeat_empty":        "repeat rule is empty",
+		"repeat_unknown_type": "repeat rule must start with d or y",
+		"repeat_missing":      "rule d requires the number of days",
+		"repeat_not_a_number": "number of days must be an integer",
+		"repeat_out_of_range": "number of days must be between 1 and 400",
+		"repeat_unexpected":   "unexpected values in the repeat rule",
+
+		"schema_violation":  "request body does not match the schema",
+		"schema_type":       "wrong value type",
+		"schema_required":   "field is required",
+		"schema_additional": "unknown field",
+		"schema_enum":       "value is not allowed",
+		"schema_pattern":    "value does not match the format",
+	},
+}
+
+// message returns the text for code in lang, falling back to the default
+// language and then to the error's own text.
+func message(lang, code string, err error) string {
+	if msg, ok := messages[lang][code]; ok {
+		return msg
+	}
+	if msg, ok := messages[defaultLang][code]; ok {
+		return msg
+	}
+	return err.Error()
+}
+
+// language picks the response language: an explicit "lang" query parameter
+// or cookie wins over Accept-Language, Russian is used when nothing matches.
+func language(r *http.Request) string {
+	if lang := r.URL.Query().Get("lang"); supported(lang) {
+		return lang
+	}
+	if c, err := r.Cookie("lang"); err == nil && supported(c.Value) {
+		return c.Value
+	}
+	return negotiate(r.Header.Get("Accept-Language"))
+}
+
+func supported(lang string) bool {
+	_, ok := messages[lang]
+	return ok
+}
+
+func negotiate(header string) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+	var list []candidate
+
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if q > 0 && supported(base) {
+			list = append(list, candidate{base, q})
+		}
+	}
+
+	sort.SliceStable(list, func(i, j int) bool { return list[i].q > list[j].q })
+	if len(list) == 0 {
+		return defaultLang
+	}
+	return list[0].lang
+}
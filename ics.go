@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const icsMaxLine = 75
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// calendar serves the tasks as an iCalendar feed. Calendar apps can't send
+// headers, so the token is a query parameter. Tasks are all-day VEVENTs,
+// or VTODOs with kind=todo.
+func (s *Server) calendar(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	if kind != "" && kind != "event" && kind != "todo" {
+		writeError(w, r, &APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: "kind", Err: ErrBadFormat})
+		return
+	}
+
+	tasks, err := s.m.CalendarTasks(r.URL.Query().Get("token"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	writeICS(&buf, tasks, kind == "todo", time.Now())
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="tasks.ics"`)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Write(buf.Bytes())
+}
+
+// writeICS writes tasks as a VCALENDAR feed.
+func writeICS(w io.Writer, tasks []Task, todo bool, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+
+	icsBegin(w)
+	icsLine(w, "METHOD:PUBLISH")
+	icsLine(w, "X-WR-CALNAME:Планировщик")
+	icsLine(w, "REFRESH-INTERVAL;VALUE=DURATION:PT1H")
+	icsLine(w, "X-PUBLISHED-TTL:PT1H")
+	for i := range tasks {
+		icsTask(w, &tasks[i], taskUID(tasks[i].ID), todo, stamp)
+	}
+	icsLine(w, "END:VCALENDAR")
+}
+
+func icsBegin(w io.Writer) {
+	icsLine(w, "BEGIN:VCALENDAR")
+	icsLine(w, "VERSION:2.0")
+	icsLine(w, "PRODID:-//go_final_project//Todo Scheduler//RU")
+	icsLine(w, "CALSCALE:GREGORIAN")
+}
+
+// icsTask writes t as an all-day VEVENT or as a VTODO due on its date. The
+// repeat rule becomes an RRULE and the version the SEQUENCE.
+func icsTask(w io.Writer, t *Task, uid string, todo bool, stamp string) {
+	date, err := time.Parse("20060102", t.Date)
+	if err != nil {
+		return
+	}
+	component := "VEVENT"
+	if todo {
+		component = "VTODO"
+	}
+
+	icsLine(w, "BEGIN:"+component)
+	icsLine(w, "UID:"+uid)
+	icsLine(w, "DTSTAMP:"+stamp)
+	icsLine(w, "SEQUENCE:"+strconv.FormatInt(t.Version, 10))
+	icsLine(w, "SUMMARY:"+icsEscaper.Replace(t.Title))
+	if t.Comment != "" {
+		icsLine(w, "DESCRIPTION:"+icsEscaper.Replace(t.Comment))
+	}
+	icsLine(w, "DTSTART;VALUE=DATE:"+t.Date)
+	if todo {
+		icsLine(w, "DUE;VALUE=DATE:"+t.Date)
+	} else {
+		// DTEND is exclusive, the event lasts the whole task date.
+		icsLine(w, "DTEND;VALUE=DATE:"+date.AddDate(0, 0, 1).Format("20060102"))
+		icsLine(w, "TRANSP:TRANSPARENT")
+	}
+	if rule, err := ParseRepeat(t.Repeat); t.Repeat != "" && err == nil {
+		icsLine(w, "RRULE:"+rule.RRULE())
+	}
+	icsLine(w, "END:"+component)
+}
+
+func taskUID(id string) string {
+	return "task-" + id + "@todo-scheduler"
+}
+
+// icsLine writes a content line folded at 75 octets without splitting
+// UTF-8 sequences. Continuation lines start with a space.
+func icsLine(w io.Writer, line string) {
+	limit := icsMaxLine
+	for len(line) > limit {
+		cut := limit
+		for !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		io.WriteString(w, line[:cut]+"\r\n ")
+		line = line[cut:]
+		limit = icsMaxLine - 1
+	}
+	io.WriteString(w, line+"\r\n")
+}
+
+func (s *Server) getCalendarTokens(w http.ResponseWriter, r *http.Request) {
+	list, err := s.m.GetCalendarTokens()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tokens": list})
+}
+
+func (s *Server) createCalendarToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	t, err := s.m.AddCalendarToken(req.Name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, t)
+}
+
+func (s *Server) deleteCalendarToken(w http.ResponseWriter, r *http.Request) {
+	if err := s.m.DeleteCalendarToken(r.PathValue("id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// icsProp is one content line, NAME;PARAM=VALUE:value. The parameters are
+// not needed for the mapping and are dropped.
+type icsProp struct {
+	Name  string
+	Value string
+}
+
+var icsUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+// icsIgnored are properties with no meaning for a task that are left out
+// of the unmapped list.
+var icsIgnored = map[string]bool{
+	"UID": true, "DTSTAMP": true, "SEQUENCE": true, "CREATED": true, "LAST-MODIFIED": true,
+	"DTEND": true, "DURATION": true, "TRANSP": true, "CLASS": true,
+}
+
+// parseICS reads the VEVENT and VTODO entries of a calendar. Other
+// components, such as VTIMEZONE, are skipped; a component nested in an
+// entry, such as VALARM, is reported as unmapped.
+func parseICS(data []byte) ([]importEntry, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.NewReplacer("\n ", "", "\n\t", "").Replace(text)
+
+	var (
+		entries []importEntry
+		props   []icsProp
+		stack   []string
+	)
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		p, ok := parseICSLine(line)
+		if !ok {
+			return nil, ErrBadCalendar
+		}
+		if len(stack) == 0 && (p.Name != "BEGIN" || !strings.EqualFold(p.Value, "VCALENDAR")) {
+			return nil, ErrBadCalendar
+		}
+
+		switch p.Name {
+		case "BEGIN":
+			name := strings.ToUpper(p.Value)
+			if n := len(stack); n > 0 && (stack[n-1] == "VEVENT" || stack[n-1] == "VTODO") {
+				props = append(props, icsProp{Name: name})
+			}
+			if name == "VEVENT" || name == "VTODO" {
+				props = props[:0]
+			}
+			stack = append(stack, name)
+		case "END":
+			n := len(stack)
+			if !strings.EqualFold(p.Value, stack[n-1]) {
+				return nil, ErrBadCalendar
+			}
+			if stack[n-1] == "VEVENT" || stack[n-1] == "VTODO" {
+				entries = append(entries, icsEntry(stack[n-1], props))
+			}
+			stack = stack[:n-1]
+		default:
+			if n := len(stack); stack[n-1] == "VEVENT" || stack[n-1] == "VTODO" {
+				props = append(props, p)
+			}
+		}
+	}
+	if len(stack) != 0 {
+		return nil, ErrBadCalendar
+	}
+	return entries, nil
+}
+
+// parseICSLine splits a content line at the first colon outside of a
+// quoted parameter value.
+func parseICSLine(line string) (icsProp, bool) {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case ':':
+			if quoted {
+				continue
+			}
+			name, _, _ := strings.Cut(line[:i], ";")
+			return icsProp{Name: strings.ToUpper(name), Value: line[i+1:]}, name != ""
+		}
+	}
+	return icsProp{}, false
+}
+
+// icsEntry maps an entry to a task. The date is DUE for todos and DTSTART
+// otherwise, the time of day is dropped.
+func icsEntry(typ string, props []icsProp) importEntry {
+	e := importEntry{Type: typ}
+	var (
+		dtstart, due, rrule string
+		completed           bool
+	)
+	for _, p := range props {
+		switch p.Name {
+		case "UID":
+			e.UID = p.Value
+		case "SUMMARY":
+			e.Task.Title = icsUnescaper.Replace(p.Value)
+		case "DESCRIPTION":
+			e.Task.Comment = icsUnescaper.Replace(p.Value)
+		case "DTSTART":
+			dtstart = icsDate(p)
+		case "DUE":
+			due = icsDate(p)
+		case "RRULE":
+			rrule = p.Value
+		case "STATUS":
+			completed = strings.EqualFold(p.Value, "COMPLETED") || strings.EqualFold(p.Value, "CANCELLED")
+		case "COMPLETED":
+			completed = true
+		default:
+			if !icsIgnored[p.Name] && !strings.HasPrefix(p.Name, "X-") && !slices.Contains(e.Unmapped, p.Name) {
+				e.Unmapped = append(e.Unmapped, p.Name)
+			}
+		}
+	}
+
+	e.Task.Date = dtstart
+	if typ == "VTODO" && due != "" {
+		e.Task.Date = due
+	}
+	if rrule != "" {
+		var lost []string
+		e.Task.Repeat, lost = repeatFromRRULE(rrule, e.Task.Date)
+		e.Unmapped = append(e.Unmapped, lost...)
+	}
+
+	switch {
+	case completed:
+		e.Err = ErrCompleted
+	case typ == "VEVENT" && e.Task.Repeat == "" && e.Task.Date != "" && e.Task.Date < time.Now().Format("20060102"):
+		e.Err = ErrPastEvent
+	}
+	return e
+}
+
+// icsDate returns the date of a DATE or DATE-TIME value as YYYYMMDD. UTC
+// times are converted to the local date, others are taken as they are.
+func icsDate(p icsProp) string {
+	if t, err := time.Parse("20060102T150405Z", p.Value); err == nil {
+		return t.Local().Format("20060102")
+	}
+	if len(p.Value) >= 8 {
+		return p.Value[:8]
+	}
+	return p.Value
+}
+
+// repeatFromRRULE translates the rules that have a repeat equivalent:
+// daily and weekly ones up to maxRepeatDays apart and plain yearly ones.
+// The repeat counts from date, so COUNT, UNTIL and a BYDAY, BYMONTH or
+// BYMONTHDAY other than that of date are dropped and returned as lost. A
+// rule that can't be translated is lost as a whole.
+func repeatFromRRULE(rrule, date string) (string, []string) {
+	start, _ := time.Parse("20060102", date)
+
+	parts := map[string]string{}
+	for _, part := range strings.Split(rrule, ";") {
+		k, v, _ := strings.Cut(part, "=")
+		parts[strings.ToUpper(k)] = strings.ToUpper(v)
+	}
+
+	interval := 1
+	if v, ok := parts["INTERVAL"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return "", []string{"RRULE"}
+		}
+		interval = n
+	}
+
+	var lost []string
+	for k := range parts {
+		switch k {
+		case "FREQ", "INTERVAL", "WKST":
+		case "COUNT", "UNTIL":
+			lost = append(lost, "RRULE:"+k)
+		case "BYDAY", "BYMONTH", "BYMONTHDAY":
+			// One weekday of a weekly rule or one date of a yearly rule
+			// repeat the date of DTSTART.
+			fits := parts["FREQ"] == "WEEKLY" && k == "BYDAY" || parts["FREQ"] == "YEARLY" && k != "BYDAY"
+			if !fits || strings.Contains(parts[k], ",") {
+				return "", []string{"RRULE"}
+			}
+			if !rruleFits(k, parts[k], start) {
+				lost = append(lost, "RRULE:"+k)
+			}
+		default:
+			return "", []string{"RRULE"}
+		}
+	}
+	sort.Strings(lost)
+
+	days := 0
+	switch parts["FREQ"] {
+	case "DAILY":
+		days = interval
+	case "WEEKLY":
+		days = 7 * interval
+	case "YEARLY":
+		if interval == 1 {
+			return "y", lost
+		}
+	}
+	if days < 1 || days > maxRepeatDays {
+		return "", []string{"RRULE"}
+	}
+	return "d " + strconv.Itoa(days), lost
+}
+
+var icsWeekdays = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// rruleFits reports whether the BYDAY, BYMONTH or BYMONTHDAY value picks
+// the day of date.
+func rruleFits(part, value string, date time.Time) bool {
+	switch part {
+	case "BYDAY":
+		return value == icsWeekdays[date.Weekday()]
+	case "BYMONTH":
+		n, err := strconv.Atoi(value)
+		return err == nil && n == int(date.Month())
+	default:
+		n, err := strconv.Atoi(value)
+		return err == nil && n == date.Day()
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const defaultIdempotencyTTL = 24 * time.Hour
+
+func idempotencyTTL() time.Duration {
+	return envDuration("TODO_IDEMPOTENCY_TTL", defaultIdempotencyTTL)
+}
+
+// envDuration reads a positive duration such as "90s" from the environment.
+func envDuration(name string, def time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Ignoring invalid %s %q", name, v)
+	}
+	return def
+}
+
+// recorder keeps a copy of the response so it can be replayed later.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotent makes retries with the same Idempotency-Key header replay the
+// first response instead of running next again. Server errors are not kept,
+// so such requests can be retried with the same key.
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, r, ErrBadFormat)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+		hash := hex.EncodeToString(sum[:])
+
+		prev, err := s.m.ReserveIdempotencyKey(key, hash, s.idempotencyTTL)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if prev != nil {
+			switch {
+			case prev.Hash != hash:
+				writeError(w, r, ErrIdemReused)
+			case prev.Status == 0:
+				writeError(w, r, ErrIdemBusy)
+			default:
+				w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(prev.Status)
+				w.Write(prev.Body)
+			}
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		next(rec, r)
+
+		if rec.status >= http.StatusInternalServerError || rec.status == 0 {
+			err = s.m.ReleaseIdempotencyKey(key)
+		} else {
+			err = s.m.SaveIdempotencyKey(key, rec.status, rec.body.Bytes())
+		}
+		if err != nil {
+			log.Printf("Failed to store idempotency key: %v", err)
+		}
+	}
+}
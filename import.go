@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxImportSize = 5 << 20
+
+// importEntry is one task read from an uploaded file. Err is set when the
+// entry is skipped before validation, Unmapped lists the source fields that
+// have no place in a task.
+type importEntry struct {
+	Type     string
+	UID      string
+	Task     Task
+	Unmapped []string
+	Err      error
+}
+
+type importItem struct {
+	Index    int      `json:"index"`
+	Type     string   `json:"type,omitempty"`
+	UID      string   `json:"uid,omitempty"`
+	Status   string   `json:"status"`
+	ID       string   `json:"id,omitempty"`
+	Task     *Task    `json:"task,omitempty"`
+	Unmapped []string `json:"unmapped,omitempty"`
+	Replaced bool     `json:"replaced,omitempty"`
+	Code     string   `json:"code,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+type importReport struct {
+	DryRun   bool         `json:"dry_run"`
+	Imported int          `json:"imported"`
+	Skipped  int          `json:"skipped"`
+	Items    []importItem `json:"items"`
+}
+
+func (s *Server) importICS(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := dryRunParam(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	data, err := readUpload(w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	entries, err := parseICS(data)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.runImport(w, r, entries, dryRun, func(tasks []Task) ([]BatchResult, error) {
+		return s.m.ImportTasks(tasks, dryRun)
+	})
+}
+
+func dryRunParam(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("dry_run")
+	if v == "" {
+		return false, nil
+	}
+	dryRun, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, &APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: "dry_run", Err: ErrBadFormat}
+	}
+	return dryRun, nil
+}
+
+// readUpload returns the "file" field of a multipart form or else the
+// request body itself.
+func readUpload(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	var src io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		f, _, err := r.FormFile("file")
+		if errors.As(err, new(*http.MaxBytesError)) {
+			return nil, ErrTooLarge
+		}
+		if err != nil {
+			return nil, &APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: "file", Err: ErrBadFormat}
+		}
+		defer f.Close()
+		src = f
+	}
+
+	data, err := io.ReadAll(src)
+	if errors.As(err, new(*http.MaxBytesError)) {
+		return nil, ErrTooLarge
+	}
+	return data, err
+}
+
+// runImport imports the entries that were not skipped while reading with
+// store and reports the outcome of every entry. Nothing is stored with dryRun.
+func (s *Server) runImport(w http.ResponseWriter, r *http.Request, entries []importEntry, dryRun bool,
+	store func(tasks []Task) ([]BatchResult, error)) {
+	var (
+		tasks []Task
+		index []int
+	)
+	for i := range entries {
+		if entries[i].Err == nil {
+			tasks = append(tasks, entries[i].Task)
+			index = append(index, i)
+		}
+	}
+
+	results, err := store(tasks)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	replaced := make([]bool, len(entries))
+	for i, res := range results {
+		if dryRun {
+			tasks[i].ID = entries[index[i]].Task.ID
+		}
+		entries[index[i]].Task = tasks[i]
+		entries[index[i]].Err = res.Err
+		replaced[index[i]] = res.Replaced
+	}
+
+	lang := language(r)
+	report := importReport{DryRun: dryRun, Items: make([]importItem, len(entries))}
+	for i, e := range entries {
+		item := importItem{Index: i, Type: e.Type, UID: e.UID, Unmapped: e.Unmapped}
+		if e.Err != nil {
+			ae := toAPIError(e.Err)
+			item.Status = "skipped"
+			item.Code = ae.Code
+			item.Error = message(lang, ae.Code, ae.Err)
+			report.Skipped++
+		} else {
+			item.Task = &entries[i].Task
+			item.Replaced = replaced[i]
+			item.Status = "imported"
+			if dryRun {
+				item.Status = "valid"
+			} else {
+				item.ID = e.Task.ID
+			}
+			report.Imported++
+		}
+		report.Items[i] = item
+	}
+	writeJSON(w, http.StatusOK, report)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"os"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -13,6 +14,13 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatalf("Command %s failed: %v", os.Args[1], err)
+		}
+		return
+	}
+
 	db, err := NewStorage()
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
@@ -21,6 +29,16 @@ func main() {
 
 	service := NewService(db)
 
+	if addr := os.Getenv("TODO_GRPC_ADDR"); addr != "" {
+		go func() {
+			if err := NewGRPCServer(service).Start(addr); err != nil {
+				log.Fatalf("Failed to start the gRPC server: %v", err)
+			}
+		}()
+	}
+
+	go NewDispatcher(service).Run()
+
 	server := NewServer(service)
 	if err = server.Start(); err != nil {
 		log.Fatalf("Failed to start the server: %v", err)
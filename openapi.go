@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed api/openapi.json
+var openapiSpec []byte
+
+// maxBodySize limits the JSON bodies checked against the spec.
+const maxBodySize = 1 << 20
+
+var (
+	openapiDoc = mustParseSpec(openapiSpec)
+
+	// openapiPatterns holds the compiled pattern of every schema by source.
+	openapiPatterns = mustCompilePatterns(openapiDoc)
+)
+
+func mustParseSpec(data []byte) map[string]any {
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		panic("api/openapi.json: " + err.Error())
+	}
+	return doc
+}
+
+func mustCompilePatterns(doc map[string]any) map[string]*regexp.Regexp {
+	patterns := map[string]*regexp.Regexp{}
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if pattern, ok := v["pattern"].(string); ok {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					panic(fmt.Sprintf("api/openapi.json: pattern %q: %v", pattern, err))
+				}
+				patterns[pattern] = re
+			}
+			for _, item := range v {
+				walk(item)
+			}
+		case []any:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+	walk(doc)
+	return patterns
+}
+
+// schemaError points at the first part of a request body that doesn't match
+// the schema. Reason is a stable code, see the schema_* catalogue entries.
+type schemaError struct {
+	Path   string
+	Reason string
+}
+
+func (e *schemaError) Error() string {
+	return ErrSchema.Error() + ": " + e.Path + " (" + e.Reason + ")"
+}
+
+func (e *schemaError) Unwrap() error {
+	return ErrSchema
+}
+
+func (s *Server) openapi(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Write(openapiSpec)
+}
+
+// validated checks the JSON body against components/schemas/<schema> of the
+// OpenAPI document before passing the request on.
+func (s *Server) validated(schema string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if errors.As(err, new(*http.MaxBytesError)) {
+			writeError(w, r, ErrTooLarge)
+			return
+		}
+		if err != nil {
+			writeError(w, r, ErrBadFormat)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var v any
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err = dec.Decode(&v); err != nil {
+			writeError(w, r, newAPIError(http.StatusBadRequest, "invalid_json", ErrBadFormat))
+			return
+		}
+
+		if err = validateSchema(schemaRef(schema), v, ""); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func schemaRef(name string) map[string]any {
+	components, _ := openapiDoc["components"].(map[string]any)
+	schemas, _ := components["schemas"].(map[string]any)
+	schema, _ := schemas[name].(map[string]any)
+	return schema
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// validateSchema supports the subset of the OpenAPI schema object used in
+// api/openapi.json: $ref, type, nullable, properties, required,
+// additionalProperties, items, enum and pattern.
+func validateSchema(schema map[string]any, v any, path string) error {
+	if schema == nil {
+		return nil
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		return validateSchema(schemaRef(strings.TrimPrefix(ref, "#/components/schemas/")), v, path)
+	}
+
+	if v == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable || schema["type"] == nil {
+			return nil
+		}
+		return &schemaError{Path: path, Reason: "type"}
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return &schemaError{Path: path, Reason: "type"}
+		}
+		return validateObject(schema, obj, path)
+
+	case "array":
+		list, ok := v.([]any)
+		if !ok {
+			return &schemaError{Path: path, Reason: "type"}
+		}
+		items, _ := schema["items"].(map[string]any)
+		for i, item := range list {
+			if err := validateSchema(items, item, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return &schemaError{Path: path, Reason: "type"}
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !openapiPatterns[pattern].MatchString(str) {
+			return &schemaError{Path: path, Reason: "pattern"}
+		}
+
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			return &schemaError{Path: path, Reason: "type"}
+		}
+		f, err := n.Float64()
+		if err != nil || (schema["type"] == "integer" && f != math.Trunc(f)) {
+			return &schemaError{Path: path, Reason: "type"}
+		}
+
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return &schemaError{Path: path, Reason: "type"}
+		}
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		for _, e := range enum {
+			if e == v {
+				return nil
+			}
+		}
+		return &schemaError{Path: path, Reason: "enum"}
+	}
+	return nil
+}
+
+func validateObject(schema map[string]any, obj map[string]any, path string) error {
+	required, _ := schema["required"].([]any)
+	for _, name := range required {
+		if _, ok := obj[name.(string)]; !ok {
+			return &schemaError{Path: joinPath(path, name.(string)), Reason: "required"}
+		}
+	}
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	props, _ := schema["properties"].(map[string]any)
+	for _, k := range keys {
+		prop, known := props[k].(map[string]any)
+		if !known {
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					return &schemaError{Path: joinPath(path, k), Reason: "additional"}
+				}
+			case map[string]any:
+				prop = extra
+			}
+		}
+		if err := validateSchema(prop, obj[k], joinPath(path, k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
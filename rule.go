@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxRepeatDays = 400
+
+// Rule is a parsed Task.Repeat: "d <days>" or "y".
+type Rule struct {
+	Type string `json:"type"`
+	Days int    `json:"days,omitempty"`
+}
+
+// RuleError explains which part of a repeat rule could not be parsed.
+// Reason is a stable code, see the repeat_* entries of the message catalogue.
+type RuleError struct {
+	Part   string
+	Value  string
+	Reason string
+}
+
+func (e *RuleError) Error() string {
+	return ErrBadVal.Error() + ": " + e.Part + " " + strconv.Quote(e.Value) + " (" + e.Reason + ")"
+}
+
+func (e *RuleError) Unwrap() error {
+	return ErrBadVal
+}
+
+func ParseRepeat(repeat string) (*Rule, error) {
+	fields := strings.Fields(repeat)
+	if len(fields) == 0 {
+		return nil, &RuleError{Part: "type", Value: repeat, Reason: "empty"}
+	}
+
+	switch fields[0] {
+	case "d":
+		if len(fields) < 2 {
+			return nil, &RuleError{Part: "days", Reason: "missing"}
+		}
+		if len(fields) > 2 {
+			return nil, &RuleError{Part: "days", Value: strings.Join(fields[2:], " "), Reason: "unexpected"}
+		}
+		days, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, &RuleError{Part: "days", Value: fields[1], Reason: "not_a_number"}
+		}
+		if days < 1 || days > maxRepeatDays {
+			return nil, &RuleError{Part: "days", Value: fields[1], Reason: "out_of_range"}
+		}
+		return &Rule{Type: "d", Days: days}, nil
+
+	case "y":
+		if len(fields) > 1 {
+			return nil, &RuleError{Part: "years", Value: strings.Join(fields[1:], " "), Reason: "unexpected"}
+		}
+		return &Rule{Type: "y"}, nil
+
+	default:
+		return nil, &RuleError{Part: "type", Value: fields[0], Reason: "unknown_type"}
+	}
+}
+
+func (r *Rule) String() string {
+	if r.Type == "d" {
+		return "d " + strconv.Itoa(r.Days)
+	}
+	return r.Type
+}
+
+// RRULE returns the iCalendar recurrence rule for r.
+func (r *Rule) RRULE() string {
+	if r.Type == "d" {
+		return "FREQ=DAILY;INTERVAL=" + strconv.Itoa(r.Days)
+	}
+	return "FREQ=YEARLY"
+}
+
+// TodoTxt returns the value of the todo.txt rec: extension for r.
+func (r *Rule) TodoTxt() string {
+	switch {
+	case r.Type == "y":
+		return "1y"
+	case r.Days%7 == 0:
+		return strconv.Itoa(r.Days/7) + "w"
+	default:
+		return strconv.Itoa(r.Days) + "d"
+	}
+}
+
+// step returns the date one repetition after t.
+func (r *Rule) step(t time.Time) time.Time {
+	if r.Type == "d" {
+		return t.AddDate(0, 0, r.Days)
+	}
+	return t.AddDate(1, 0, 0)
+}
+
+// Next returns the first repetition of date that is later than both date and now.
+func (r *Rule) Next(now time.Time, date time.Time) time.Time {
+	next := r.step(date)
+	for next.Before(now) {
+		next = r.step(next)
+	}
+	return next
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,19 +16,60 @@ type TodoList interface {
 	AddTask(task *Task) (string, error)
 	GetTask(id string) (*Task, error)
 	GetTasks() (*TaskList, error)
+	FindTasks(f TaskFilter) (*TaskList, int, error)
+	Occurrences(t *Task, n int) ([]string, error)
 	UpdateTask(task *Task) error
-	DeleteTask(id string) error
+	PatchTask(id string, p *TaskPatch, version int64) (*Task, error)
+	DeleteTask(id string, version int64) error
 	ValidTaskAndModify(t *Task) (*Task, error)
-	DoneTask(id string) error
+	DoneTask(id string, version int64) (*Task, error)
+	Batch(ops []BatchOp) ([]BatchResult, bool, error)
+	MoveOverdue() ([]string, error)
+	ReserveIdempotencyKey(key, hash string, ttl time.Duration) (*IdempotentResponse, error)
+	SaveIdempotencyKey(key string, status int, body []byte) error
+	ReleaseIdempotencyKey(key string) error
+	GetRevisions(id string) (*RevisionList, error)
+	RevertTask(id string, revisionId string, version int64) error
+	Backup(dest string) error
 	NextDate(now time.Time, date string, repeat string) (string, error)
+	Subscribe() (<-chan Event, func())
+	AddWebhook(w *Webhook) error
+	GetWebhooks() ([]Webhook, error)
+	DeleteWebhook(id string) error
+	GetDeliveries(webhookId string) ([]Delivery, error)
+	Redeliver(webhookId, id string) error
+	AddCalendarToken(name string) (*CalendarToken, error)
+	GetCalendarTokens() ([]CalendarToken, error)
+	DeleteCalendarToken(id string) error
+	CalendarTasks(token string) ([]Task, error)
+	ImportTasks(tasks []Task, dryRun bool) ([]BatchResult, error)
+	ExportTasks() ([]Task, error)
+	Agenda(from, to string) (*Agenda, error)
+	RestoreTasks(tasks []Task, conflict string, dryRun bool) ([]BatchResult, error)
+	CalDAVObjects() ([]CalDAVObject, int64, error)
+	CalDAVObject(name string) (*CalDAVObject, error)
+	CalDAVChanges(token int64) ([]CalDAVObject, []string, int64, error)
+	PutCalDAVObject(obj *CalDAVObject, completed bool, version int64, noOverwrite bool) (bool, error)
+	DeleteCalDAVObject(name string, version int64) error
 }
 
 type Server struct {
 	m TodoList
+
+	requireIfMatch bool
+	adminToken     string
+	idempotencyTTL time.Duration
+	hub            *Hub
 }
 
 func NewServer(td TodoList) *Server {
-	s := &Server{m: td}
+	s := &Server{
+		m:              td,
+		requireIfMatch: os.Getenv("TODO_REQUIRE_IF_MATCH") != "0",
+		adminToken:     os.Getenv("TODO_ADMIN_TOKEN"),
+		idempotencyTTL: idempotencyTTL(),
+		hub:            NewHub(),
+	}
 	s.startHandlers()
 	return s
 }
@@ -44,107 +89,288 @@ func (s *Server) startHandlers() {
 	http.HandleFunc("GET /api/nextdate", s.nextDate)
 	http.HandleFunc("GET /api/task", s.getTask)
 	http.HandleFunc("GET /api/tasks", s.getAllTasks)
+	http.HandleFunc("GET /api/task/revisions", s.getRevisions)
+	http.HandleFunc("GET /api/admin/backup", s.admin(s.backup))
+	http.HandleFunc("GET /api/openapi.json", s.openapi)
+	http.HandleFunc("GET /api/events", s.events)
+	http.HandleFunc("GET /api/ws", s.ws)
+	http.HandleFunc("GET /api/webhooks", s.admin(s.getWebhooks))
+	http.HandleFunc("GET /api/webhooks/{id}/deliveries", s.admin(s.getDeliveries))
+	http.HandleFunc("POST /api/webhooks", s.admin(s.validated("WebhookInput", s.createWebhook)))
+	http.HandleFunc("POST /api/webhooks/{id}/deliveries/{delivery}/redeliver", s.admin(s.redeliver))
+	http.HandleFunc("DELETE /api/webhooks/{id}", s.admin(s.deleteWebhook))
+	http.HandleFunc("GET /api/calendar.ics", s.calendar)
+	http.HandleFunc("GET /api/calendar/tokens", s.admin(s.getCalendarTokens))
+	http.HandleFunc("POST /api/calendar/tokens", s.admin(s.validated("CalendarTokenInput", s.createCalendarToken)))
+	http.HandleFunc("DELETE /api/calendar/tokens/{id}", s.admin(s.deleteCalendarToken))
+	http.HandleFunc("GET /api/export", s.admin(s.exportTasks))
+	http.HandleFunc("GET /api/agenda", s.agenda)
+	http.HandleFunc("GET /api/agenda.pdf", s.agendaPDF)
+	http.HandleFunc("POST /api/import", s.admin(s.importTasks))
+	http.HandleFunc("POST /api/import/ics", s.importICS)
+	http.HandleFunc("/caldav/", s.davAuth(s.caldav))
+	http.HandleFunc("/.well-known/caldav", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, davRoot, http.StatusMovedPermanently)
+	})
 
 	http.HandleFunc("POST /api/task/done", s.doneTask)
-	http.HandleFunc("POST /api/task", s.createTask)
+	http.HandleFunc("POST /api/task", s.validated("Task", s.idempotent(s.createTask)))
+	http.HandleFunc("POST /api/task/revert", s.revertTask)
+	http.HandleFunc("POST /api/tasks/batch", s.validated("BatchRequest", s.batch))
+	http.HandleFunc("POST /api/tasks/done", s.validated("IdList", s.doneTasks))
+	http.HandleFunc("POST /api/tasks/reschedule-overdue", s.rescheduleOverdue)
+	http.HandleFunc("POST /api/graphql", s.validated("GraphQLRequest", s.graphql()))
 
-	http.HandleFunc("PUT /api/task", s.updateTask)
+	http.HandleFunc("PUT /api/task", s.validated("Task", s.updateTask))
+	http.HandleFunc("PATCH /api/task", s.validated("TaskPatch", s.patchTask))
 
 	http.HandleFunc("DELETE /api/task", s.deleteTask)
+
+	s.startHandlersV2()
 }
 
+// nextDate answers with the next date as plain text, or as JSON with the
+// parsed rule when format=json. Any invalid parameter is a 400.
 func (s *Server) nextDate(w http.ResponseWriter, r *http.Request) {
 	now := r.FormValue("now")
 	date := r.FormValue("date")
 	repeat := r.FormValue("repeat")
 
+	today := time.Now().Format("20060102")
+	if now == "" {
+		now = today
+	}
+
 	nowTime, err := time.Parse("20060102", now)
 	if err != nil {
+		writeError(w, r, &APIError{Status: http.StatusBadRequest, Code: "invalid_date", Field: "now", Err: ErrBadDate})
+		return
+	}
+	if date == "" {
+		writeError(w, r, &APIError{Status: http.StatusBadRequest, Code: "date_required", Field: "date", Err: ErrEmptyDate})
 		return
 	}
 
 	nextDate, err := s.m.NextDate(nowTime, date, repeat)
 	if err != nil {
+		e := toAPIError(err)
+		if e.Status < http.StatusInternalServerError {
+			e.Status = http.StatusBadRequest
+		}
+		writeError(w, r, e)
 		return
 	}
 
+	if r.FormValue("format") == "json" {
+		rule, _ := ParseRepeat(repeat)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"next_date": nextDate,
+			"now":       now,
+			"date":      date,
+			"repeat":    repeat,
+			"rule":      rule,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
 	w.Write([]byte(nextDate))
 }
 
 func (s *Server) doneTask(w http.ResponseWriter, r *http.Request) {
-
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-
 	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeError(w, r, ErrEmptyId)
+		return
+	}
 
-	err := s.m.DoneTask(id)
+	version, err := s.ifMatch(r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	if _, err = s.m.DoneTask(id, version); err != nil {
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{}`))
+	writeJSON(w, http.StatusOK, struct{}{})
 }
 
 func (s *Server) updateTask(w http.ResponseWriter, r *http.Request) {
-	var t *Task
-
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	t := &Task{}
 
-	err := json.NewDecoder(r.Body).Decode(&t)
+	version, err := s.ifMatch(r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		writeError(w, r, err)
+		return
+	}
+
+	if err = decodeJSON(r, t); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if t.ID == "" {
+		writeError(w, r, ErrEmptyId)
 		return
 	}
 
 	t, err = s.m.ValidTaskAndModify(t)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
+	t.Version = version
 	if err := s.m.UpdateTask(t); err != nil {
-		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, ErrSqlExec.Error()), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{}`))
+	w.Header().Set("ETag", etag(t.Version))
+	writeJSON(w, http.StatusOK, struct{}{})
 }
 
-func (s *Server) getTask(w http.ResponseWriter, r *http.Request) {
+// patchTask applies a JSON Merge Patch (RFC 7396): absent fields are kept,
+// null clears a field. The id comes from the query or the patch itself.
+func (s *Server) patchTask(w http.ResponseWriter, r *http.Request) {
+	version, err := s.ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	id, p, err := decodePatch(r, r.URL.Query().Get("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	t, err := s.m.PatchTask(id, p, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("ETag", etag(t.Version))
+	writeJSON(w, http.StatusOK, t)
+}
 
+// decodePatch reads a merge patch from the body. An "id" in the patch must
+// agree with id, if one is given.
+func decodePatch(r *http.Request, id string) (string, *TaskPatch, error) {
+	var raw map[string]json.RawMessage
+
+	if err := decodeJSON(r, &raw); err != nil {
+		return "", nil, err
+	}
+
+	p := &TaskPatch{}
+	for key, value := range raw {
+		var field **string
+		switch key {
+		case "id":
+			var bodyId string
+			if json.Unmarshal(value, &bodyId) != nil || (id != "" && bodyId != id) {
+				return "", nil, ErrId
+			}
+			id = bodyId
+			continue
+		case "date":
+			field = &p.Date
+		case "title":
+			field = &p.Title
+		case "comment":
+			field = &p.Comment
+		case "repeat":
+			field = &p.Repeat
+		default:
+			return "", nil, &APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: key, Err: ErrBadFormat}
+		}
+
+		var v *string
+		if err := json.Unmarshal(value, &v); err != nil {
+			return "", nil, &APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: key, Err: ErrBadFormat}
+		}
+		if v == nil {
+			v = new(string)
+		}
+		*field = v
+	}
+	if id == "" {
+		return "", nil, ErrEmptyId
+	}
+	return id, p, nil
+}
+
+func (s *Server) getTask(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 	if id == "" {
-		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, ErrEmptyId.Error()), http.StatusBadRequest)
+		writeError(w, r, ErrEmptyId)
 		return
 	}
 
 	t, err := s.m.GetTask(id)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(t.Version))
+	if r.Header.Get("If-None-Match") == etag(t.Version) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, t)
+}
+
+func (s *Server) getRevisions(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeError(w, r, ErrEmptyId)
 		return
 	}
 
-	res, err := json.Marshal(t)
+	rl, err := s.m.GetRevisions(id)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(res)
+
+	if rl.Revisions == nil {
+		rl.Revisions = []Revision{}
+	}
+
+	writeJSON(w, http.StatusOK, rl)
 }
 
-func (s *Server) getAllTasks(w http.ResponseWriter, r *http.Request) {
-	var tl *TaskList
+func (s *Server) revertTask(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	revision := r.URL.Query().Get("revision")
+	if id == "" || revision == "" {
+		writeError(w, r, ErrEmptyId)
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	version, err := s.ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
+	if err := s.m.RevertTask(id, revision, version); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+func (s *Server) getAllTasks(w http.ResponseWriter, r *http.Request) {
 	tl, err := s.m.GetTasks()
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, ErrSqlExec.Error()), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -152,55 +378,202 @@ func (s *Server) getAllTasks(w http.ResponseWriter, r *http.Request) {
 		tl.Tasks = []Task{}
 	}
 
-	res, err := json.Marshal(tl)
+	writeJSON(w, http.StatusOK, tl)
+}
+
+func (s *Server) createTask(w http.ResponseWriter, r *http.Request) {
+	task := &Task{}
+
+	if err := decodeJSON(r, task); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	task, err := s.m.ValidTaskAndModify(task)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, ErrBadFormat.Error()), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write(res)
-}
+	id, err := s.m.AddTask(task)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-func (s *Server) createTask(w http.ResponseWriter, r *http.Request) {
-	var task *Task
+	writeJSON(w, http.StatusCreated, map[string]string{"id": id})
+}
 
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+func (s *Server) deleteTask(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeError(w, r, ErrEmptyId)
+		return
+	}
 
-	err := json.NewDecoder(r.Body).Decode(&task)
+	version, err := s.ifMatch(r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	task, err = s.m.ValidTaskAndModify(task)
-	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+	if err = s.m.DeleteTask(id, version); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	id, err := s.m.AddTask(task)
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+type batchItem struct {
+	Index   int    `json:"index"`
+	Op      string `json:"op"`
+	ID      string `json:"id,omitempty"`
+	Status  int    `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Skipped bool   `json:"skipped,omitempty"`
+}
+
+func (s *Server) batch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Operations []BatchOp `json:"operations"`
+	}
+
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.runBatch(w, r, req.Operations)
+}
+
+func (s *Server) doneTasks(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs []string `json:"ids"`
+	}
+
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	ops := make([]BatchOp, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		ops = append(ops, BatchOp{Op: "done", ID: id})
+	}
+	s.runBatch(w, r, ops)
+}
+
+// runBatch answers 200 when all operations were committed, otherwise with
+// the status of the failed operation; nothing is applied in that case.
+func (s *Server) runBatch(w http.ResponseWriter, r *http.Request, ops []BatchOp) {
+	results, committed, err := s.m.Batch(ops)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte(fmt.Sprintf(`{"id":"%v"}`, id)))
+	status := http.StatusOK
+	lang := language(r)
+	items := make([]batchItem, len(ops))
+	for i, op := range ops {
+		items[i] = batchItem{Index: i, Op: op.Op, ID: op.ID, Skipped: i >= len(results)}
+		if i >= len(results) {
+			continue
+		}
+
+		res := results[i]
+		items[i].ID = res.ID
+		if res.Err != nil {
+			e := toAPIError(res.Err)
+			items[i].Status = e.Status
+			items[i].Code = e.Code
+			items[i].Error = message(lang, e.Code, e.Err)
+			status = e.Status
+			continue
+		}
+		items[i].Status = http.StatusOK
+		if op.Op == "create" {
+			items[i].Status = http.StatusCreated
+		}
+	}
 
+	writeJSON(w, status, map[string]any{
+		"committed": committed,
+		"results":   items,
+	})
 }
 
-func (s *Server) deleteTask(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+func (s *Server) rescheduleOverdue(w http.ResponseWriter, r *http.Request) {
+	ids, err := s.m.MoveOverdue()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ids": ids})
+}
 
-	id := r.URL.Query().Get("id")
+// admin lets the request through only with "Authorization: Bearer $TODO_ADMIN_TOKEN".
+// Admin routes are disabled while the token is not configured.
+func (s *Server) admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(s.adminToken, r.Header.Get("Authorization")) {
+			writeError(w, r, ErrForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// authorized checks an Authorization header value against the admin token.
+func authorized(adminToken, header string) bool {
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	return adminToken != "" && ok && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
 
-	err := s.m.DeleteTask(id)
+func (s *Server) backup(w http.ResponseWriter, r *http.Request) {
+	f, err := os.CreateTemp("", "scheduler-backup-*.db")
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, ErrSqlExec.Error()), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if err = s.m.Backup(f.Name()); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{}`))
+	name := fmt.Sprintf("scheduler-%s.db", time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeFile(w, r, f.Name())
+}
+
+func etag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// ifMatch returns the task version the client expects, 0 means any version
+// ("If-Match: *"). Without TODO_REQUIRE_IF_MATCH=0 the header is required.
+func (s *Server) ifMatch(r *http.Request) (int64, error) {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" && s.requireIfMatch {
+		return 0, ErrNoIfMatch
+	}
+	return parseIfMatch(h)
+}
+
+func parseIfMatch(h string) (int64, error) {
+	h = strings.TrimSpace(h)
+	if h == "" || h == "*" {
+		return 0, nil
+	}
+
+	version, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(h, "W/"), `"`), 10, 64)
+	if err != nil || version < 1 {
+		return 0, ErrVersion
+	}
+	return version, nil
 }
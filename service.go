@@ -1,19 +1,87 @@
 package main
 
 import (
-	"strconv"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"slices"
 	"strings"
 	"time"
 )
 
 type Service struct {
-	db *Storage
+	db     *Storage
+	events *Broker
+
+	// queued is signalled after a commit that published events, their
+	// webhook deliveries are stored by then.
+	queued chan struct{}
+
+	// pending collects the events of the current transaction, nil outside one.
+	pending *[]Event
 }
 
 func NewService(db *Storage) *Service {
 	return &Service{
-		db: db,
+		db:     db,
+		events: NewBroker(),
+		queued: make(chan struct{}, 1),
+	}
+}
+
+// tx runs fn with a Service bound to a single storage transaction. Events
+// published by fn are delivered only after the outermost transaction commits.
+func (s *Service) tx(fn func(svc *Service) error) error {
+	pending := s.pending
+	if pending == nil {
+		pending = &[]Event{}
 	}
+
+	err := s.db.Tx(func(tx *Storage) error {
+		svc := *s
+		svc.db = tx
+		svc.pending = pending
+		return fn(&svc)
+	})
+	if err == nil && s.pending == nil && len(*pending) > 0 {
+		for _, e := range *pending {
+			s.events.Publish(e)
+		}
+		s.signalQueued()
+	}
+	return err
+}
+
+func (s *Service) signalQueued() {
+	select {
+	case s.queued <- struct{}{}:
+	default:
+	}
+}
+
+// publish stores the webhook deliveries of the event in the transaction of
+// the change itself, so they are committed or lost together with it.
+// Subscribers get the event after the commit.
+func (s *Service) publish(typ string, t *Task, deleted bool) error {
+	if s.pending == nil {
+		return s.tx(func(svc *Service) error {
+			return svc.publish(typ, t, deleted)
+		})
+	}
+
+	e := Event{Type: typ, Task: *t, Version: t.Version, Deleted: deleted}
+	if err := s.queueDeliveries(e); err != nil {
+		return err
+	}
+	*s.pending = append(*s.pending, e)
+	return nil
+}
+
+func (s *Service) Subscribe() (<-chan Event, func()) {
+	return s.events.Subscribe()
 }
 
 func (s *Service) GetTask(id string) (*Task, error) {
@@ -21,43 +89,339 @@ func (s *Service) GetTask(id string) (*Task, error) {
 }
 
 func (s *Service) AddTask(task *Task) (string, error) {
-	return s.db.AddTask(task)
+	var id string
+	err := s.tx(func(svc *Service) error {
+		var err error
+		id, err = svc.db.AddTask(task)
+		if err != nil {
+			return err
+		}
+
+		// New rows start at version 1, see migrations.
+		created := *task
+		created.ID = id
+		created.Version = 1
+		return svc.publish(EventCreated, &created, false)
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
 }
 
 func (s *Service) GetTasks() (*TaskList, error) {
 	return s.db.GetTasks()
 }
 
-func (s *Service) UpdateTask(task *Task) error {
-	return s.db.UpdateTask(task)
+// FindTasks returns one page of the tasks matching f and the number of all
+// matching tasks. Titles may be encrypted in the database, so the text
+// search and paging are done here rather than in SQL.
+func (s *Service) FindTasks(f TaskFilter) (*TaskList, int, error) {
+	for _, date := range []string{f.From, f.To} {
+		if _, err := time.Parse("20060102", date); date != "" && err != nil {
+			return nil, 0, ErrBadDate
+		}
+	}
+	if f.Limit <= 0 || f.Offset < 0 {
+		return nil, 0, ErrBadFormat
+	}
+
+	tl, err := s.db.FindTasks(f.From, f.To, f.Repeating)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tasks := tl.Tasks
+	if search := strings.ToLower(f.Search); search != "" {
+		tasks = tasks[:0]
+		for _, t := range tl.Tasks {
+			if strings.Contains(strings.ToLower(t.Title), search) ||
+				strings.Contains(strings.ToLower(t.Comment), search) {
+				tasks = append(tasks, t)
+			}
+		}
+	}
+
+	total := len(tasks)
+	tasks = tasks[min(f.Offset, total):min(f.Offset+f.Limit, total)]
+	return &TaskList{Tasks: tasks}, total, nil
 }
 
-func (s *Service) DeleteTask(id string) error {
-	return s.db.DeleteTask(id)
+// Occurrences returns the task date followed by its next repetitions,
+// n dates at most.
+func (s *Service) Occurrences(t *Task, n int) ([]string, error) {
+	if n <= 0 {
+		return nil, ErrBadFormat
+	}
+
+	date, err := time.Parse("20060102", t.Date)
+	if err != nil {
+		return nil, ErrBadDate
+	}
+
+	dates := []string{t.Date}
+	if t.Repeat == "" {
+		return dates, nil
+	}
+
+	rule, err := ParseRepeat(t.Repeat)
+	if err != nil {
+		return nil, err
+	}
+	for len(dates) < n {
+		date = rule.Next(date, date)
+		dates = append(dates, date.Format("20060102"))
+	}
+	return dates, nil
+}
+
+func (s *Service) UpdateTask(task *Task) error {
+	return s.tx(func(svc *Service) error {
+		if err := svc.db.UpdateTask(task); err != nil {
+			return err
+		}
+		return svc.publish(EventUpdated, task, false)
+	})
 }
 
-func (s *Service) DoneTask(id string) error {
-	task, err := s.db.GetTaskById(id)
+// PatchTask applies only the changed fields and validates only them,
+// so unlike ValidTaskAndModify it never moves the date on its own.
+func (s *Service) PatchTask(id string, p *TaskPatch, version int64) (*Task, error) {
+	var task *Task
+	err := s.tx(func(svc *Service) error {
+		var err error
+		task, err = svc.db.GetTaskById(id)
+		if err != nil {
+			return err
+		}
+		if version != 0 && task.Version != version {
+			return ErrVersion
+		}
+
+		if p.Title != nil {
+			if strings.TrimSpace(*p.Title) == "" {
+				return ErrEmptyTitle
+			}
+			task.Title = *p.Title
+		}
+		if p.Comment != nil {
+			task.Comment = *p.Comment
+		}
+		if p.Date != nil {
+			if *p.Date == "" {
+				return ErrEmptyDate
+			}
+			if _, err = time.Parse("20060102", *p.Date); err != nil {
+				return ErrBadDate
+			}
+			task.Date = *p.Date
+		}
+		if p.Repeat != nil {
+			if *p.Repeat != "" {
+				if _, err = ParseRepeat(*p.Repeat); err != nil {
+					return err
+				}
+			}
+			task.Repeat = *p.Repeat
+		}
+
+		return svc.UpdateTask(task)
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return task, nil
+}
+
+func (s *Service) DeleteTask(id string, version int64) error {
+	return s.tx(func(svc *Service) error {
+		task, err := svc.db.GetTaskById(id)
+		if err != nil {
+			return err
+		}
+		if err = svc.db.DeleteTask(id, version); err != nil {
+			return err
+		}
+		return svc.publish(EventDeleted, task, true)
+	})
+}
 
-	if task.Repeat == "" {
-		return s.db.DeleteTask(task.ID)
+func (s *Service) GetRevisions(id string) (*RevisionList, error) {
+	if _, err := s.db.GetTaskById(id); err != nil {
+		return nil, err
 	}
+	return s.db.GetRevisions(id)
+}
+
+func (s *Service) RevertTask(id string, revisionId string, version int64) error {
+	return s.tx(func(svc *Service) error {
+		rev, err := svc.db.GetRevision(id, revisionId)
+		if err != nil {
+			return err
+		}
+
+		return svc.UpdateTask(&Task{
+			ID:      id,
+			Date:    rev.Date,
+			Title:   rev.Title,
+			Comment: rev.Comment,
+			Repeat:  rev.Repeat,
+			Version: version,
+		})
+	})
+}
 
-	task.Date, err = s.NextDate(time.Now(), task.Date, task.Repeat)
+// DoneTask deletes a one-off task or moves a repeating one to its next date.
+// The returned task is nil when it was deleted.
+func (s *Service) DoneTask(id string, version int64) (*Task, error) {
+	var done *Task
+	err := s.tx(func(svc *Service) error {
+		task, err := svc.db.GetTaskById(id)
+		if err != nil {
+			return err
+		}
+
+		if version != 0 && task.Version != version {
+			return ErrVersion
+		}
+
+		if task.Repeat == "" {
+			if err = svc.db.DeleteTask(task.ID, task.Version); err != nil {
+				return err
+			}
+			return svc.publish(EventDone, task, true)
+		}
+
+		task.Date, err = svc.NextDate(time.Now(), task.Date, task.Repeat)
+		if err != nil {
+			return err
+		}
+
+		if err = svc.db.UpdateTask(task); err != nil {
+			return err
+		}
+		done = task
+		return svc.publish(EventDone, task, false)
+	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return done, nil
+}
+
+func (s *Service) Backup(dest string) error {
+	return s.db.Backup(dest)
+}
+
+const maxBatchOps = 1000
+
+// Batch runs ops in one transaction. It stops at the first failing
+// operation and rolls everything back; results cover the ops that ran.
+func (s *Service) Batch(ops []BatchOp) ([]BatchResult, bool, error) {
+	if len(ops) > maxBatchOps {
+		return nil, false, ErrBatchSize
+	}
+
+	var (
+		results []BatchResult
+		failed  bool
+	)
+	err := s.tx(func(svc *Service) error {
+		for _, op := range ops {
+			id, err := svc.batchOp(op)
+			results = append(results, BatchResult{ID: id, Err: err})
+			if err != nil {
+				failed = true
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && !failed {
+		return nil, false, err
+	}
+	return results, !failed, nil
+}
+
+func (s *Service) batchOp(op BatchOp) (string, error) {
+	switch op.Op {
+	case "create":
+		if op.Task == nil {
+			return "", ErrBadTask
+		}
+		t, err := s.ValidTaskAndModify(op.Task)
+		if err != nil {
+			return "", err
+		}
+		return s.AddTask(t)
+
+	case "update":
+		if op.Task == nil {
+			return "", ErrBadTask
+		}
+		if op.Task.ID == "" {
+			return "", ErrEmptyId
+		}
+		t, err := s.ValidTaskAndModify(op.Task)
+		if err != nil {
+			return op.Task.ID, err
+		}
+		t.Version = op.Version
+		return t.ID, s.UpdateTask(t)
+
+	case "delete":
+		if op.ID == "" {
+			return "", ErrEmptyId
+		}
+		return op.ID, s.DeleteTask(op.ID, op.Version)
+
+	case "done":
+		if op.ID == "" {
+			return "", ErrEmptyId
+		}
+		_, err := s.DoneTask(op.ID, op.Version)
+		return op.ID, err
+
+	default:
+		return op.ID, ErrBatchOp
 	}
+}
+
+// MoveOverdue sets the date of every task planned before today to today.
+func (s *Service) MoveOverdue() ([]string, error) {
+	today := time.Now().Format("20060102")
+	ids := []string{}
 
-	err = s.db.UpdateTask(task)
+	err := s.tx(func(svc *Service) error {
+		tl, err := svc.db.GetTasksBefore(today)
+		if err != nil {
+			return err
+		}
+		for _, t := range tl.Tasks {
+			t.Date = today
+			if err = svc.UpdateTask(&t); err != nil {
+				return err
+			}
+			ids = append(ids, t.ID)
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return ids, nil
+}
 
-	return nil
+func (s *Service) ReserveIdempotencyKey(key, hash string, ttl time.Duration) (*IdempotentResponse, error) {
+	return s.db.ReserveIdempotencyKey(key, hash, ttl)
+}
 
+func (s *Service) SaveIdempotencyKey(key string, status int, body []byte) error {
+	return s.db.SaveIdempotencyKey(key, status, body)
+}
+
+func (s *Service) ReleaseIdempotencyKey(key string) error {
+	return s.db.ReleaseIdempotencyKey(key)
 }
 
 func (s *Service) ValidTaskAndModify(t *Task) (*Task, error) {
@@ -92,54 +456,572 @@ func (s *Service) ValidTaskAndModify(t *Task) (*Task, error) {
 }
 
 func (s *Service) NextDate(now time.Time, date string, repeat string) (string, error) {
-	if repeat == "" {
-		return "", ErrBadVal
+	rule, err := ParseRepeat(repeat)
+	if err != nil {
+		return "", err
+	}
+
+	planDate, err := time.Parse("20060102", date)
+	if err != nil {
+		return "", ErrBadDate
+	}
+
+	return rule.Next(now, planDate).Format("20060102"), nil
+}
+
+// ImportTasks adds the valid tasks in one transaction. Invalid tasks are left
+// out with the error in their result. With dryRun tasks are only validated,
+// in both cases they are adjusted like by ValidTaskAndModify.
+func (s *Service) ImportTasks(tasks []Task, dryRun bool) ([]BatchResult, error) {
+	results := make([]BatchResult, len(tasks))
+	for i := range tasks {
+		_, results[i].Err = s.ValidTaskAndModify(&tasks[i])
+	}
+	if dryRun {
+		return results, nil
 	}
 
-	if repeat[0] != 'd' && repeat[0] != 'y' {
-		return "", ErrBadVal
+	err := s.tx(func(svc *Service) error {
+		for i := range tasks {
+			if results[i].Err != nil {
+				continue
+			}
+			id, err := svc.AddTask(&tasks[i])
+			if err != nil {
+				return err
+			}
+			tasks[i].ID = id
+			results[i].ID = id
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return results, nil
+}
 
-	if repeat[0] == 'd' && len(repeat) < 3 {
-		return "", ErrBadVal
+// Agenda returns the agenda of the YYYYMMDD dates from to to. Past days are
+// not planned, from before today is ErrBadFormat.
+func (s *Service) Agenda(from, to string) (*Agenda, error) {
+	start, err := time.Parse("20060102", from)
+	if err != nil {
+		return nil, ErrBadDate
+	}
+	end, err := time.Parse("20060102", to)
+	if err != nil {
+		return nil, ErrBadDate
 	}
 
-	switch repeat[0] {
+	today := time.Now().Format("20060102")
+	if from < today {
+		return nil, ErrBadFormat
+	}
 
-	case 'd':
+	tl, err := s.db.FindTasks("", to, nil)
+	if err != nil {
+		return nil, err
+	}
 
-		repDays, err := strconv.Atoi(repeat[2:])
+	agenda := &Agenda{From: from, To: to}
+	days := make(map[string][]Task)
+	for _, t := range tl.Tasks {
+		// A late repeating task is overdue and still has its next
+		// occurrences in the period.
+		if t.Date < today {
+			agenda.Overdue = append(agenda.Overdue, t)
+		}
+		date, err := time.Parse("20060102", t.Date)
 		if err != nil {
-			return "", err
+			continue
 		}
-		if repDays < 1 || repDays > 400 {
-			return "", ErrBadVal
+		var rule *Rule
+		if t.Repeat != "" {
+			if rule, err = ParseRepeat(t.Repeat); err != nil {
+				rule = nil
+			}
 		}
+		for !date.After(end) {
+			if !date.Before(start) {
+				day := date.Format("20060102")
+				days[day] = append(days[day], t)
+			}
+			if rule == nil {
+				break
+			}
+			date = rule.Next(date, date)
+		}
+	}
+
+	for date := start; !date.After(end); date = date.AddDate(0, 0, 1) {
+		day := date.Format("20060102")
+		if tasks := days[day]; len(tasks) > 0 {
+			agenda.Days = append(agenda.Days, AgendaDay{Date: day, Tasks: tasks})
+		}
+	}
+	return agenda, nil
+}
+
+// Conflict modes of RestoreTasks for a task whose id is already taken.
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictDuplicate = "duplicate"
+)
+
+var errDryRun = errors.New("dry run")
+
+// ExportTasks returns every task ordered by date.
+func (s *Service) ExportTasks() ([]Task, error) {
+	tl, err := s.db.FindTasks("", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return tl.Tasks, nil
+}
+
+// RestoreTasks adds tasks read from an export in one transaction. Unlike
+// ImportTasks the tasks are stored as they are, past dates included. A task
+// keeps its id while the id is free, otherwise conflict decides: skip fails
+// the task with ErrTaskExists, overwrite replaces the stored task and
+// duplicate adds it under a new id. With dryRun the transaction is rolled
+// back, so the results still show the conflicts.
+func (s *Service) RestoreTasks(tasks []Task, conflict string, dryRun bool) ([]BatchResult, error) {
+	results := make([]BatchResult, len(tasks))
+	err := s.tx(func(svc *Service) error {
+		for i := range tasks {
+			res, err := svc.restoreTask(&tasks[i], conflict)
+			if err != nil {
+				return err
+			}
+			results[i] = res
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return nil, err
+	}
+	return results, nil
+}
+
+// restoreTask stores t, only storage failures are returned as err.
+func (s *Service) restoreTask(t *Task, conflict string) (BatchResult, error) {
+	if err := checkTask(t); err != nil {
+		return BatchResult{ID: t.ID, Err: err}, nil
+	}
+	if t.ID == "" {
+		id, err := s.AddTask(t)
+		t.ID = id
+		return BatchResult{ID: id}, err
+	}
+
+	if id, err := parseId(t.ID); err != nil || id <= 0 {
+		return BatchResult{ID: t.ID, Err: ErrId}, nil
+	}
+	_, err := s.db.GetTaskById(t.ID)
+	if errors.Is(err, ErrSearchTask) {
+		if err = s.db.InsertTask(t); err != nil {
+			return BatchResult{}, err
+		}
+		created := *t
+		created.Version = 1
+		return BatchResult{ID: t.ID}, s.publish(EventCreated, &created, false)
+	}
+	if err != nil {
+		return BatchResult{}, err
+	}
+
+	switch conflict {
+	case ConflictOverwrite:
+		t.Version = 0
+		return BatchResult{ID: t.ID, Replaced: true}, s.UpdateTask(t)
+	case ConflictDuplicate:
+		id, err := s.AddTask(t)
+		t.ID = id
+		return BatchResult{ID: id}, err
+	default:
+		return BatchResult{ID: t.ID, Err: ErrTaskExists}, nil
+	}
+}
+
+// checkTask validates t without changing it.
+func checkTask(t *Task) error {
+	if strings.TrimSpace(t.Title) == "" {
+		return ErrEmptyTitle
+	}
+	if t.Date == "" {
+		return ErrEmptyDate
+	}
+	if _, err := time.Parse("20060102", t.Date); err != nil {
+		return ErrBadDate
+	}
+	if t.Repeat != "" {
+		if _, err := ParseRepeat(t.Repeat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var webhookEvents = []string{EventCreated, EventUpdated, EventDone, EventDeleted, EventOverdue}
+
+// AddWebhook checks and stores w. A random secret is generated when w has none.
+func (s *Service) AddWebhook(w *Webhook) error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrHookURL
+	}
+	for _, e := range w.Events {
+		if !slices.Contains(webhookEvents, e) {
+			return ErrHookEvent
+		}
+	}
+	if w.Events == nil {
+		w.Events = []string{}
+	}
+
+	if w.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err = rand.Read(secret); err != nil {
+			return err
+		}
+		w.Secret = hex.EncodeToString(secret)
+	}
+	w.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	w.ID, err = s.db.AddWebhook(w)
+	return err
+}
+
+func (s *Service) GetWebhooks() ([]Webhook, error) {
+	return s.db.GetWebhooks()
+}
+
+func (s *Service) DeleteWebhook(id string) error {
+	return s.db.DeleteWebhook(id)
+}
+
+func (s *Service) GetDeliveries(webhookId string) ([]Delivery, error) {
+	return s.db.GetDeliveries(webhookId)
+}
 
-		planDate, err := time.Parse("20060102", date)
+func (s *Service) Redeliver(webhookId, id string) error {
+	return s.db.Redeliver(webhookId, id)
+}
+
+// queueDeliveries stores e for every webhook subscribed to it.
+func (s *Service) queueDeliveries(e Event) error {
+	payload, err := json.Marshal(map[string]any{
+		"event":      e.Type,
+		"created_at": time.Now().UTC().Format(time.RFC3339),
+		"task":       e.Task,
+		"version":    e.Version,
+		"deleted":    e.Deleted,
+	})
+	if err != nil {
+		return err
+	}
+	return s.db.QueueDeliveries(e.Type, payload)
+}
+
+// Queued is signalled when committed changes may have queued webhook deliveries.
+func (s *Service) Queued() <-chan struct{} {
+	return s.queued
+}
+
+func (s *Service) DueDeliveries(limit int, skipHooks []string) ([]Delivery, error) {
+	return s.db.DueDeliveries(time.Now(), limit, skipHooks)
+}
+
+func (s *Service) SaveDelivery(d *Delivery) error {
+	return s.db.SaveDelivery(d)
+}
+
+// NotifyOverdue queues an overdue webhook delivery once for every task whose
+// date has passed. A task moved to a new date that passes too is reported
+// again. The event only goes to webhooks: SSE and WebSocket subscribers
+// follow changes of tasks, and a task does not change by becoming overdue.
+func (s *Service) NotifyOverdue() error {
+	today := time.Now().Format("20060102")
+
+	queued := false
+	err := s.tx(func(svc *Service) error {
+		if err := svc.db.ForgetOverdue(); err != nil {
+			return err
+		}
+		tl, err := svc.db.GetTasksBefore(today)
 		if err != nil {
-			return "", err
+			return err
+		}
+		for i := range tl.Tasks {
+			t := &tl.Tasks[i]
+			fresh, err := svc.db.MarkOverdue(t.ID, t.Date)
+			if err != nil {
+				return err
+			}
+			if fresh {
+				e := Event{Type: EventOverdue, Task: *t, Version: t.Version}
+				if err = svc.queueDeliveries(e); err != nil {
+					return err
+				}
+				queued = true
+			}
 		}
+		return nil
+	})
+	if err == nil && queued {
+		s.signalQueued()
+	}
+	return err
+}
+
+// AddCalendarToken creates a feed token for name. Only its hash is stored.
+func (s *Service) AddCalendarToken(name string) (*CalendarToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrEmptyName
+	}
+
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	t := &CalendarToken{
+		Name:      name,
+		Token:     hex.EncodeToString(token),
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	id, err := s.db.AddCalendarToken(t, hashToken(t.Token))
+	if err != nil {
+		return nil, err
+	}
+	t.ID = id
+	return t, nil
+}
+
+func (s *Service) GetCalendarTokens() ([]CalendarToken, error) {
+	return s.db.GetCalendarTokens()
+}
+
+func (s *Service) DeleteCalendarToken(id string) error {
+	return s.db.DeleteCalendarToken(id)
+}
+
+// CalendarTasks returns all tasks for the feed opened with token.
+func (s *Service) CalendarTasks(token string) ([]Task, error) {
+	if token == "" {
+		return nil, ErrForbidden
+	}
+	ok, err := s.db.CalendarTokenExists(hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrForbidden
+	}
+
+	tl, err := s.db.FindTasks("", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return tl.Tasks, nil
+}
 
-		for planDate.Before(now) || date >= planDate.Format("20060102") {
-			planDate = planDate.AddDate(0, 0, repDays)
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CalDAVObjects returns all tasks as CalDAV resources with the sync token
+// of this state.
+func (s *Service) CalDAVObjects() ([]CalDAVObject, int64, error) {
+	var (
+		list  []CalDAVObject
+		token int64
+	)
+	err := s.tx(func(svc *Service) error {
+		var err error
+		if token, err = svc.db.SyncToken(); err != nil {
+			return err
+		}
+		names, err := svc.db.CalDAVNames()
+		if err != nil {
+			return err
+		}
+		tl, err := svc.db.FindTasks("", "", nil)
+		if err != nil {
+			return err
+		}
+		for _, t := range tl.Tasks {
+			list = append(list, caldavObject(t, names))
 		}
+		return nil
+	})
+	return list, token, err
+}
+
+func (s *Service) CalDAVObject(name string) (*CalDAVObject, error) {
+	id, err := s.caldavTaskID(name)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.db.GetTaskById(id)
+	if err != nil {
+		return nil, err
+	}
+	names, err := s.db.CalDAVNames()
+	if err != nil {
+		return nil, err
+	}
+	obj := caldavObject(*t, names)
+	return &obj, nil
+}
 
-		return planDate.Format("20060102"), nil
+// CalDAVChanges returns the tasks changed after token, the names of the
+// deleted ones and the current token. Token 0 stands for the initial sync
+// and returns all tasks. Only the latest changes are kept, older tokens fail
+// with ErrSyncToken and the client has to sync from scratch.
+func (s *Service) CalDAVChanges(token int64) ([]CalDAVObject, []string, int64, error) {
+	if token == 0 {
+		list, current, err := s.CalDAVObjects()
+		return list, nil, current, err
+	}
 
-	case 'y':
-		planDate, err := time.Parse("20060102", date)
+	var (
+		changed []CalDAVObject
+		deleted []string
+		current int64
+	)
+	err := s.tx(func(svc *Service) error {
+		var err error
+		if current, err = svc.db.SyncToken(); err != nil {
+			return err
+		}
+		oldest, err := svc.db.OldestChange()
 		if err != nil {
-			return "", err
+			return err
+		}
+		if token < 0 || token > current || token < oldest-1 {
+			return ErrSyncToken
+		}
+		changes, err := svc.db.ChangesSince(token)
+		if err != nil {
+			return err
+		}
+		names, err := svc.db.CalDAVNames()
+		if err != nil {
+			return err
+		}
+		for _, c := range changes {
+			if c.Deleted {
+				deleted = append(deleted, c.Name)
+				continue
+			}
+			t, err := svc.db.GetTaskById(c.TaskID)
+			if err != nil {
+				return err
+			}
+			changed = append(changed, caldavObject(*t, names))
+		}
+		return nil
+	})
+	return changed, deleted, current, err
+}
+
+// PutCalDAVObject stores obj.Task under obj.Name and reports whether a task
+// was created. Completing a todo marks the task done. A non-zero version
+// must match the stored one, with noOverwrite the task must not exist yet.
+func (s *Service) PutCalDAVObject(obj *CalDAVObject, completed bool, version int64, noOverwrite bool) (bool, error) {
+	created := false
+	err := s.tx(func(svc *Service) error {
+		id, err := svc.caldavTaskID(obj.Name)
+		if errors.Is(err, ErrSearchTask) {
+			if version != 0 {
+				return ErrVersion
+			}
+			if completed {
+				return ErrCompleted
+			}
+			if _, err = svc.ValidTaskAndModify(&obj.Task); err != nil {
+				return err
+			}
+			if obj.Task.ID, err = svc.AddTask(&obj.Task); err != nil {
+				return err
+			}
+			obj.Task.Version = 1
+			created = true
+			return svc.db.SaveCalDAVName(obj.Task.ID, obj.Name, obj.UID)
 		}
+		if err != nil {
+			return err
+		}
+		if noOverwrite {
+			return ErrVersion
+		}
+
+		if completed {
+			t, err := svc.DoneTask(id, version)
+			if err != nil {
+				return err
+			}
+			obj.Task = Task{}
+			if t != nil {
+				obj.Task = *t
+			}
+			return nil
+		}
+		obj.Task.ID = id
+		if _, err = svc.ValidTaskAndModify(&obj.Task); err != nil {
+			return err
+		}
+		obj.Task.Version = version
+		return svc.UpdateTask(&obj.Task)
+	})
+	return created, err
+}
 
-		for planDate.Before(now) || date >= planDate.Format("20060102") {
-			planDate = planDate.AddDate(1, 0, 0)
+func (s *Service) DeleteCalDAVObject(name string, version int64) error {
+	return s.tx(func(svc *Service) error {
+		id, err := svc.caldavTaskID(name)
+		if err != nil {
+			return err
 		}
+		return svc.DeleteTask(id, version)
+	})
+}
 
-		return planDate.Format("20060102"), nil
+// caldavTaskID finds the task a client stored under name, tasks created
+// elsewhere are named by their id.
+func (s *Service) caldavTaskID(name string) (string, error) {
+	id, err := s.db.CalDAVTaskID(name)
+	if !errors.Is(err, ErrSearchTask) {
+		return id, err
+	}
 
-	default:
-		return "", ErrBadVal
+	id, ok := strings.CutSuffix(name, ".ics")
+	if !ok {
+		return "", ErrSearchTask
+	}
+	if _, err = parseId(id); err != nil {
+		return "", ErrSearchTask
+	}
+	if _, err = s.db.GetTaskById(id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func caldavObject(t Task, names map[string]CalDAVObject) CalDAVObject {
+	obj := CalDAVObject{Name: t.ID + ".ics", UID: taskUID(t.ID), Task: t}
+	if n, ok := names[t.ID]; ok {
+		obj.Name = n.Name
+		if n.UID != "" {
+			obj.UID = n.UID
+		}
 	}
+	return obj
 }
@@ -2,41 +2,215 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"strconv"
+	"strings"
+	"time"
 )
 
+type queryer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+// Storage runs queries either directly on the database or, inside Tx, on a transaction.
 type Storage struct {
-	db *sql.DB
+	db     *sql.DB
+	q      queryer
+	tx     *sql.Tx
+	cipher *Cipher
 }
 
 func NewStorage() (*Storage, error) {
-	sqlDB, err := sql.Open("sqlite3", dbName)
+	c, err := LoadCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	// immediate transactions take the write lock on BEGIN, so concurrent
+	// read-modify-write units of work are serialized instead of failing on upgrade.
+	sqlDB, err := sql.Open("sqlite3", dbName+"?_txlock=immediate&_busy_timeout=5000")
 	if err != nil {
 		return nil, err
 	}
 	if err := migrate(sqlDB); err != nil {
 		return nil, err
 	}
-	return &Storage{sqlDB}, nil
+	if c == nil {
+		if err := checkPlain(sqlDB); err != nil {
+			sqlDB.Close()
+			return nil, err
+		}
+	}
+	return &Storage{db: sqlDB, q: sqlDB, cipher: c}, nil
 }
 
-func migrate(d *sql.DB) error {
-	_, err := d.Exec(`
-		CREATE TABLE IF NOT EXISTS scheduler (id INTEGER PRIMARY KEY AUTOINCREMENT, date TEXT, title TEXT, comment TEXT, repeat VARCHAR(128));
-		CREATE INDEX IF NOT EXISTS idx_date ON scheduler (date);`)
+// checkPlain fails with ErrNoKey when the database holds encrypted values,
+// started without a key the server would otherwise fail on every read.
+func checkPlain(d *sql.DB) error {
+	for _, table := range []string{"scheduler", "revisions"} {
+		rows, err := d.Query("SELECT title, comment FROM " + table + " WHERE title LIKE 'enc:%' OR comment LIKE 'enc:%'")
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var title, comment string
+			if err = rows.Scan(&title, &comment); err != nil {
+				rows.Close()
+				return err
+			}
+			_, _, sealedTitle := parseSealed(title)
+			_, _, sealedComment := parseSealed(comment)
+			if sealedTitle || sealedComment {
+				rows.Close()
+				return ErrNoKey
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tx runs fn as a single unit of work. Nested calls join the outer transaction.
+func (s *Storage) Tx(fn func(tx *Storage) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.db.Begin()
 	if err != nil {
-		return ErrCreateDB
+		return err
+	}
+	defer tx.Rollback()
+
+	txs := *s
+	txs.q, txs.tx = tx, tx
+	if err = fn(&txs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrations are applied in order, PRAGMA user_version keeps the number of applied ones.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS scheduler (id INTEGER PRIMARY KEY AUTOINCREMENT, date TEXT, title TEXT, comment TEXT, repeat VARCHAR(128));
+	CREATE INDEX IF NOT EXISTS idx_date ON scheduler (date);`,
+
+	`CREATE TABLE IF NOT EXISTS revisions (id INTEGER PRIMARY KEY AUTOINCREMENT, task_id INTEGER NOT NULL, date TEXT, title TEXT, comment TEXT, repeat VARCHAR(128), created_at TEXT NOT NULL);
+	CREATE INDEX IF NOT EXISTS idx_revisions_task ON revisions (task_id);`,
+
+	`ALTER TABLE scheduler ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE revisions ADD COLUMN version INTEGER NOT NULL DEFAULT 0;`,
+
+	`CREATE TABLE IF NOT EXISTS idempotency_keys (key TEXT PRIMARY KEY, request_hash TEXT NOT NULL, status INTEGER NOT NULL DEFAULT 0, body BLOB, created_at TEXT NOT NULL);
+	CREATE INDEX IF NOT EXISTS idx_idempotency_created ON idempotency_keys (created_at);`,
+
+	`CREATE TABLE IF NOT EXISTS webhooks (id INTEGER PRIMARY KEY AUTOINCREMENT, url TEXT NOT NULL, events TEXT NOT NULL, secret TEXT NOT NULL, created_at TEXT NOT NULL);
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (id INTEGER PRIMARY KEY AUTOINCREMENT, webhook_id INTEGER NOT NULL, event TEXT NOT NULL, payload BLOB NOT NULL,
+		status TEXT NOT NULL, attempts INTEGER NOT NULL DEFAULT 0, response_code INTEGER NOT NULL DEFAULT 0, last_error TEXT NOT NULL DEFAULT '',
+		next_attempt_at TEXT NOT NULL DEFAULT '', created_at TEXT NOT NULL, delivered_at TEXT NOT NULL DEFAULT '');
+	CREATE INDEX IF NOT EXISTS idx_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+	CREATE INDEX IF NOT EXISTS idx_deliveries_webhook ON webhook_deliveries (webhook_id);
+	CREATE TABLE IF NOT EXISTS overdue_notices (task_id INTEGER NOT NULL, date TEXT NOT NULL, PRIMARY KEY (task_id, date));`,
+
+	`CREATE TABLE IF NOT EXISTS calendar_tokens (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, token_hash TEXT NOT NULL UNIQUE, created_at TEXT NOT NULL);`,
+
+	`CREATE TABLE IF NOT EXISTS caldav_objects (task_id INTEGER PRIMARY KEY, name TEXT NOT NULL UNIQUE, uid TEXT NOT NULL);
+	CREATE TABLE IF NOT EXISTS task_changes (seq INTEGER PRIMARY KEY AUTOINCREMENT, task_id INTEGER NOT NULL, name TEXT NOT NULL DEFAULT '', deleted INTEGER NOT NULL DEFAULT 0);
+	CREATE INDEX IF NOT EXISTS idx_task_changes_task ON task_changes (task_id);
+	CREATE TRIGGER IF NOT EXISTS task_inserted AFTER INSERT ON scheduler BEGIN
+		INSERT INTO task_changes (task_id) VALUES (NEW.id);
+	END;
+	CREATE TRIGGER IF NOT EXISTS task_updated AFTER UPDATE ON scheduler BEGIN
+		INSERT INTO task_changes (task_id) VALUES (NEW.id);
+	END;
+	CREATE TRIGGER IF NOT EXISTS task_deleted AFTER DELETE ON scheduler BEGIN
+		INSERT INTO task_changes (task_id, name, deleted)
+			VALUES (OLD.id, COALESCE((SELECT name FROM caldav_objects WHERE task_id = OLD.id), OLD.id || '.ics'), 1);
+		DELETE FROM caldav_objects WHERE task_id = OLD.id;
+	END;`,
+
+	`CREATE TRIGGER IF NOT EXISTS task_changes_pruned AFTER INSERT ON task_changes BEGIN
+		DELETE FROM task_changes WHERE seq <= NEW.seq - 10000;
+	END;`,
+}
+
+func migrate(d *sql.DB) error {
+	var version int
+	if err := d.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return ErrOpenDB
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := d.Begin()
+		if err != nil {
+			return ErrMigrateDb
+		}
+		if _, err = tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			if i == 0 {
+				return ErrCreateDB
+			}
+			return ErrMigrateDb
+		}
+		if _, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return ErrMigrateDb
+		}
+		if err = tx.Commit(); err != nil {
+			return ErrMigrateDb
+		}
 	}
 	return nil
 }
 
+func parseId(ids string) (int64, error) {
+	id, err := strconv.ParseInt(ids, 10, 64)
+	if err != nil {
+		return 0, ErrId
+	}
+	return id, nil
+}
+
 func (s *Storage) Close() error {
 	return s.db.Close()
 }
 
+// seal encrypts the task content when encryption at rest is configured.
+func (s *Storage) seal(title, comment string) (string, string, error) {
+	title, err := s.cipher.Encrypt("title", title)
+	if err != nil {
+		return "", "", err
+	}
+	comment, err = s.cipher.Encrypt("comment", comment)
+	if err != nil {
+		return "", "", err
+	}
+	return title, comment, nil
+}
+
+func (s *Storage) open(title, comment *string) error {
+	var err error
+	if *title, err = s.cipher.Decrypt("title", *title); err != nil {
+		return err
+	}
+	*comment, err = s.cipher.Decrypt("comment", *comment)
+	return err
+}
+
 func (s *Storage) AddTask(task *Task) (string, error) {
-	res, err := s.db.Exec("INSERT INTO scheduler (date, title, comment, repeat) VALUES (?, ?, ?, ?)",
-		task.Date, task.Title, task.Comment, task.Repeat)
+	title, comment, err := s.seal(task.Title, task.Comment)
+	if err != nil {
+		return "", err
+	}
+	res, err := s.q.Exec("INSERT INTO scheduler (date, title, comment, repeat) VALUES (?, ?, ?, ?)",
+		task.Date, title, comment, task.Repeat)
 	if err != nil {
 		return "", err
 	}
@@ -47,34 +221,93 @@ func (s *Storage) AddTask(task *Task) (string, error) {
 	return strconv.FormatInt(id, 10), nil
 }
 
+// InsertTask adds task under its own id.
+func (s *Storage) InsertTask(task *Task) error {
+	id, err := parseId(task.ID)
+	if err != nil {
+		return err
+	}
+	title, comment, err := s.seal(task.Title, task.Comment)
+	if err != nil {
+		return err
+	}
+	_, err = s.q.Exec("INSERT INTO scheduler (id, date, title, comment, repeat) VALUES (?, ?, ?, ?, ?)",
+		id, task.Date, title, comment, task.Repeat)
+	return err
+}
+
 func (s *Storage) GetTaskById(ids string) (*Task, error) {
 	var t Task
-	id, err := strconv.ParseInt(ids, 10, 64)
+	id, err := parseId(ids)
 	if err != nil {
 		return nil, err
 	}
-	err = s.db.QueryRow("SELECT * FROM scheduler WHERE id=?", id).Scan(&t.ID, &t.Date, &t.Title, &t.Comment, &t.Repeat)
+	err = s.q.QueryRow("SELECT id, date, title, comment, repeat, version FROM scheduler WHERE id=?", id).
+		Scan(&t.ID, &t.Date, &t.Title, &t.Comment, &t.Repeat, &t.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSearchTask
+	}
 	if err != nil {
 		return nil, err
 	}
+	if err = s.open(&t.Title, &t.Comment); err != nil {
+		return nil, err
+	}
 	return &t, nil
 }
 
 func (s *Storage) GetTasks() (*TaskList, error) {
+	return s.queryTasks(`SELECT id, date, title, comment, repeat, version FROM scheduler ORDER BY date ASC LIMIT 50`)
+}
+
+// GetTasksBefore returns all tasks planned earlier than date.
+func (s *Storage) GetTasksBefore(date string) (*TaskList, error) {
+	return s.queryTasks(`SELECT id, date, title, comment, repeat, version FROM scheduler
+		WHERE date < ? ORDER BY date ASC`, date)
+}
+
+// FindTasks returns the tasks planned between from and to, an empty bound is
+// open. A non-nil repeating selects tasks with or without a repeat rule.
+func (s *Storage) FindTasks(from, to string, repeating *bool) (*TaskList, error) {
+	query := `SELECT id, date, title, comment, repeat, version FROM scheduler WHERE 1=1`
+	var args []any
+	if from != "" {
+		query += ` AND date >= ?`
+		args = append(args, from)
+	}
+	if to != "" {
+		query += ` AND date <= ?`
+		args = append(args, to)
+	}
+	if repeating != nil {
+		if *repeating {
+			query += ` AND repeat <> ''`
+		} else {
+			query += ` AND repeat = ''`
+		}
+	}
+	return s.queryTasks(query+` ORDER BY date ASC, id ASC`, args...)
+}
+
+func (s *Storage) queryTasks(query string, args ...any) (*TaskList, error) {
 	var tl TaskList
-	rows, err := s.db.Query(`SELECT * FROM scheduler ORDER BY date ASC LIMIT 50`)
+	rows, err := s.q.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
 	for rows.Next() {
 		var t Task
-		err = rows.Scan(&t.ID, &t.Date, &t.Title, &t.Comment, &t.Repeat)
+		err = rows.Scan(&t.ID, &t.Date, &t.Title, &t.Comment, &t.Repeat, &t.Version)
 		if err != nil {
 			return nil, err
 		}
+		if err = s.open(&t.Title, &t.Comment); err != nil {
+			return nil, err
+		}
 		tl.Tasks = append(tl.Tasks, t)
 	}
-	defer rows.Close()
 
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -82,57 +315,551 @@ func (s *Storage) GetTasks() (*TaskList, error) {
 	return &tl, nil
 }
 
+// UpdateTask overwrites the task keeping the previous state in revisions.
+// A non-zero task.Version must match the stored one, otherwise ErrVersion is returned.
 func (s *Storage) UpdateTask(task *Task) error {
-
-	stmt, err := s.db.Prepare("UPDATE scheduler SET date=?, title=?, comment=?, repeat=? WHERE id=?")
+	id, err := parseId(task.ID)
 	if err != nil {
 		return err
 	}
 
-	id, err := strconv.ParseInt(task.ID, 10, 64)
+	title, comment, err := s.seal(task.Title, task.Comment)
 	if err != nil {
 		return err
 	}
 
-	res, err := stmt.Exec(task.Date, task.Title, task.Comment, task.Repeat, id)
+	return s.Tx(func(tx *Storage) error {
+		_, err := tx.q.Exec(`INSERT INTO revisions (task_id, date, title, comment, repeat, version, created_at)
+			SELECT id, date, title, comment, repeat, version, ? FROM scheduler WHERE id=?`,
+			time.Now().UTC().Format(time.RFC3339), id)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.q.Exec(`UPDATE scheduler SET date=?, title=?, comment=?, repeat=?, version=version+1
+			WHERE id=? AND (?=0 OR version=?)`,
+			task.Date, title, comment, task.Repeat, id, task.Version, task.Version)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return tx.versionError(id)
+		}
+
+		return tx.q.QueryRow("SELECT version FROM scheduler WHERE id=?", id).Scan(&task.Version)
+	})
+}
+
+// versionError tells a missing task from a stale version after a statement matched no rows.
+func (s *Storage) versionError(id int64) error {
+	var exists bool
+	err := s.q.QueryRow("SELECT EXISTS (SELECT 1 FROM scheduler WHERE id=?)", id).Scan(&exists)
 	if err != nil {
 		return err
 	}
+	if exists {
+		return ErrVersion
+	}
+	return ErrSearchTask
+}
 
-	rowsAffected, err := res.RowsAffected()
+func (s *Storage) GetRevisions(ids string) (*RevisionList, error) {
+	var rl RevisionList
+	id, err := parseId(ids)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.q.Query(`SELECT id, task_id, date, title, comment, repeat, version, created_at FROM revisions
+		WHERE task_id=? ORDER BY id DESC`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r Revision
+		err = rows.Scan(&r.ID, &r.TaskID, &r.Date, &r.Title, &r.Comment, &r.Repeat, &r.Version, &r.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err = s.open(&r.Title, &r.Comment); err != nil {
+			return nil, err
+		}
+		rl.Revisions = append(rl.Revisions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &rl, nil
+}
+
+func (s *Storage) GetRevision(taskIds, ids string) (*Revision, error) {
+	var r Revision
+	taskId, err := parseId(taskIds)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseId(ids)
+	if err != nil {
+		return nil, err
+	}
+	err = s.q.QueryRow(`SELECT id, task_id, date, title, comment, repeat, version, created_at FROM revisions
+		WHERE id=? AND task_id=?`, id, taskId).
+		Scan(&r.ID, &r.TaskID, &r.Date, &r.Title, &r.Comment, &r.Repeat, &r.Version, &r.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSearchRev
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = s.open(&r.Title, &r.Comment); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *Storage) DeleteTask(ids string, version int64) error {
+	id, err := parseId(ids)
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return ErrRows
+	return s.Tx(func(tx *Storage) error {
+		res, err := tx.q.Exec("DELETE FROM scheduler WHERE id=? AND (?=0 OR version=?)", id, version, version)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return tx.versionError(id)
+		}
+
+		_, err = tx.q.Exec("DELETE FROM revisions WHERE task_id=?", id)
+		return err
+	})
+}
+
+// Rekey re-encrypts the content of all tasks and revisions with the primary
+// key, so retired keys can be removed afterwards.
+func (s *Storage) Rekey() (int, error) {
+	var n int
+	err := s.Tx(func(tx *Storage) error {
+		for _, table := range []string{"scheduler", "revisions"} {
+			rows, err := tx.q.Query("SELECT id, title, comment FROM " + table)
+			if err != nil {
+				return err
+			}
+
+			type row struct {
+				id             int64
+				title, comment string
+			}
+			var list []row
+			for rows.Next() {
+				var r row
+				if err = rows.Scan(&r.id, &r.title, &r.comment); err != nil {
+					rows.Close()
+					return err
+				}
+				list = append(list, r)
+			}
+			rows.Close()
+			if err = rows.Err(); err != nil {
+				return err
+			}
+
+			for _, r := range list {
+				if err = tx.open(&r.title, &r.comment); err != nil {
+					return err
+				}
+				title, comment, err := tx.seal(r.title, r.comment)
+				if err != nil {
+					return err
+				}
+				_, err = tx.q.Exec("UPDATE "+table+" SET title=?, comment=? WHERE id=?", title, comment, r.id)
+				if err != nil {
+					return err
+				}
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// ReserveIdempotencyKey claims key for a new request. If the key was already
+// used within ttl the stored response is returned instead, Status 0 means
+// that the first request is still running.
+func (s *Storage) ReserveIdempotencyKey(key, hash string, ttl time.Duration) (*IdempotentResponse, error) {
+	var resp *IdempotentResponse
+	now := time.Now().UTC()
+
+	err := s.Tx(func(tx *Storage) error {
+		_, err := tx.q.Exec("DELETE FROM idempotency_keys WHERE created_at < ?",
+			now.Add(-ttl).Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+
+		var r IdempotentResponse
+		err = tx.q.QueryRow("SELECT request_hash, status, body FROM idempotency_keys WHERE key=?", key).
+			Scan(&r.Hash, &r.Status, &r.Body)
+		if err == nil {
+			resp = &r
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		_, err = tx.q.Exec("INSERT INTO idempotency_keys (key, request_hash, created_at) VALUES (?, ?, ?)",
+			key, hash, now.Format(time.RFC3339))
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return resp, nil
+}
+
+func (s *Storage) SaveIdempotencyKey(key string, status int, body []byte) error {
+	_, err := s.q.Exec("UPDATE idempotency_keys SET status=?, body=? WHERE key=?", status, body, key)
+	return err
+}
+
+func (s *Storage) ReleaseIdempotencyKey(key string) error {
+	_, err := s.q.Exec("DELETE FROM idempotency_keys WHERE key=?", key)
+	return err
 }
 
-func (s *Storage) DeleteTask(ids string) error {
-	stmt, err := s.db.Prepare("DELETE FROM scheduler WHERE id=?")
+func (s *Storage) AddWebhook(w *Webhook) (string, error) {
+	res, err := s.q.Exec("INSERT INTO webhooks (url, events, secret, created_at) VALUES (?, ?, ?, ?)",
+		w.URL, strings.Join(w.Events, ","), w.Secret, w.CreatedAt)
+	if err != nil {
+		return "", err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+func (s *Storage) GetWebhooks() ([]Webhook, error) {
+	rows, err := s.q.Query("SELECT id, url, events, secret, created_at FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []Webhook{}
+	for rows.Next() {
+		var (
+			w      Webhook
+			events string
+		)
+		if err = rows.Scan(&w.ID, &w.URL, &events, &w.Secret, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		w.Events = []string{}
+		if events != "" {
+			w.Events = strings.Split(events, ",")
+		}
+		hooks = append(hooks, w)
+	}
+	return hooks, rows.Err()
+}
+
+func (s *Storage) DeleteWebhook(ids string) error {
+	id, err := parseId(ids)
 	if err != nil {
 		return err
 	}
 
-	id, err := strconv.ParseInt(ids, 10, 64)
+	return s.Tx(func(tx *Storage) error {
+		res, err := tx.q.Exec("DELETE FROM webhooks WHERE id=?", id)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			if err == nil {
+				err = ErrSearchHook
+			}
+			return err
+		}
+		_, err = tx.q.Exec("DELETE FROM webhook_deliveries WHERE webhook_id=?", id)
+		return err
+	})
+}
+
+// QueueDeliveries adds a pending delivery of payload for every webhook
+// subscribed to event.
+func (s *Storage) QueueDeliveries(event string, payload []byte) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := s.q.Exec(`INSERT INTO webhook_deliveries (webhook_id, event, payload, status, next_attempt_at, created_at)
+		SELECT id, ?, ?, 'pending', ?, ? FROM webhooks WHERE events = '' OR ',' || events || ',' LIKE '%,' || ? || ',%'`,
+		event, payload, now, now, event)
+	return err
+}
+
+const deliveryColumns = `d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.response_code, d.last_error,
+	d.next_attempt_at, d.created_at, d.delivered_at, w.url, w.secret`
+
+func (s *Storage) queryDeliveries(query string, args ...any) ([]Delivery, error) {
+	rows, err := s.q.Query(`SELECT `+deliveryColumns+` FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id `+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Delivery{}
+	for rows.Next() {
+		var (
+			d       Delivery
+			payload []byte
+		)
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &d.ResponseCode,
+			&d.LastError, &d.NextAttemptAt, &d.CreatedAt, &d.DeliveredAt, &d.URL, &d.Secret)
+		if err != nil {
+			return nil, err
+		}
+		d.Payload = payload
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// DueDeliveries returns up to limit pending deliveries whose next attempt is
+// due, leaving out the webhooks in skipHooks.
+func (s *Storage) DueDeliveries(now time.Time, limit int, skipHooks []string) ([]Delivery, error) {
+	query := `WHERE d.status = 'pending' AND d.next_attempt_at <= ?`
+	args := []any{now.UTC().Format(time.RFC3339)}
+	if len(skipHooks) > 0 {
+		query += ` AND d.webhook_id NOT IN (?` + strings.Repeat(`, ?`, len(skipHooks)-1) + `)`
+		for _, id := range skipHooks {
+			args = append(args, id)
+		}
+	}
+	args = append(args, limit)
+	return s.queryDeliveries(query+` ORDER BY d.next_attempt_at, d.id LIMIT ?`, args...)
+}
+
+// GetDeliveries returns the latest 100 deliveries of a webhook, newest first.
+func (s *Storage) GetDeliveries(webhookIds string) ([]Delivery, error) {
+	id, err := parseId(webhookIds)
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	if err = s.q.QueryRow("SELECT EXISTS (SELECT 1 FROM webhooks WHERE id=?)", id).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrSearchHook
+	}
+	return s.queryDeliveries(`WHERE d.webhook_id = ? ORDER BY d.id DESC LIMIT 100`, id)
+}
+
+func (s *Storage) SaveDelivery(d *Delivery) error {
+	_, err := s.q.Exec(`UPDATE webhook_deliveries SET status=?, attempts=?, response_code=?, last_error=?,
+		next_attempt_at=?, delivered_at=? WHERE id=?`,
+		d.Status, d.Attempts, d.ResponseCode, d.LastError, d.NextAttemptAt, d.DeliveredAt, d.ID)
+	return err
+}
+
+// Redeliver queues a delivery of the webhook again, the retry count starts over.
+func (s *Storage) Redeliver(webhookIds, ids string) error {
+	webhookId, err := parseId(webhookIds)
+	if err != nil {
+		return err
+	}
+	id, err := parseId(ids)
 	if err != nil {
 		return err
 	}
 
-	res, err := stmt.Exec(id)
+	res, err := s.q.Exec(`UPDATE webhook_deliveries SET status='pending', attempts=0, next_attempt_at=?
+		WHERE id=? AND webhook_id=?`, time.Now().UTC().Format(time.RFC3339), id, webhookId)
 	if err != nil {
 		return err
 	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSearchDelivery
+	}
+	return nil
+}
+
+// MarkOverdue records that task id was reported overdue for date. It returns
+// false when that was already done.
+func (s *Storage) MarkOverdue(ids, date string) (bool, error) {
+	id, err := parseId(ids)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := s.q.Exec("INSERT OR IGNORE INTO overdue_notices (task_id, date) VALUES (?, ?)", id, date)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ForgetOverdue drops the notices of tasks that no longer exist.
+func (s *Storage) ForgetOverdue() error {
+	_, err := s.q.Exec("DELETE FROM overdue_notices WHERE task_id NOT IN (SELECT id FROM scheduler)")
+	return err
+}
 
-	rowsAffected, err := res.RowsAffected()
+func (s *Storage) AddCalendarToken(t *CalendarToken, hash string) (string, error) {
+	res, err := s.q.Exec("INSERT INTO calendar_tokens (name, token_hash, created_at) VALUES (?, ?, ?)",
+		t.Name, hash, t.CreatedAt)
+	if err != nil {
+		return "", err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+func (s *Storage) GetCalendarTokens() ([]CalendarToken, error) {
+	rows, err := s.q.Query("SELECT id, name, created_at FROM calendar_tokens ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []CalendarToken{}
+	for rows.Next() {
+		var t CalendarToken
+		if err = rows.Scan(&t.ID, &t.Name, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+func (s *Storage) DeleteCalendarToken(ids string) error {
+	id, err := parseId(ids)
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
+	res, err := s.q.Exec("DELETE FROM calendar_tokens WHERE id=?", id)
+	if err != nil {
 		return err
 	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSearchCalToken
+	}
 	return nil
 }
+
+func (s *Storage) CalendarTokenExists(hash string) (bool, error) {
+	var exists bool
+	err := s.q.QueryRow("SELECT EXISTS (SELECT 1 FROM calendar_tokens WHERE token_hash=?)", hash).Scan(&exists)
+	return exists, err
+}
+
+// SyncToken returns the number of the latest task change.
+func (s *Storage) SyncToken() (int64, error) {
+	var seq int64
+	err := s.q.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM task_changes").Scan(&seq)
+	return seq, err
+}
+
+// OldestChange returns the number of the oldest kept task change, the
+// task_changes_pruned trigger keeps the latest 10000 ones.
+func (s *Storage) OldestChange() (int64, error) {
+	var seq int64
+	err := s.q.QueryRow("SELECT COALESCE(MIN(seq), 0) FROM task_changes").Scan(&seq)
+	return seq, err
+}
+
+// ChangesSince returns the last change of every task changed after seq.
+func (s *Storage) ChangesSince(seq int64) ([]TaskChange, error) {
+	rows, err := s.q.Query(`SELECT c.task_id, c.name, c.deleted FROM task_changes c
+		JOIN (SELECT MAX(seq) AS seq FROM task_changes WHERE seq > ? GROUP BY task_id) l ON l.seq = c.seq
+		ORDER BY c.seq`, seq)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []TaskChange
+	for rows.Next() {
+		var c TaskChange
+		if err = rows.Scan(&c.TaskID, &c.Name, &c.Deleted); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+// CalDAVNames returns the resource names and UIDs given by CalDAV clients
+// by task id.
+func (s *Storage) CalDAVNames() (map[string]CalDAVObject, error) {
+	rows, err := s.q.Query("SELECT task_id, name, uid FROM caldav_objects")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := map[string]CalDAVObject{}
+	for rows.Next() {
+		var (
+			id  string
+			obj CalDAVObject
+		)
+		if err = rows.Scan(&id, &obj.Name, &obj.UID); err != nil {
+			return nil, err
+		}
+		names[id] = obj
+	}
+	return names, rows.Err()
+}
+
+// CalDAVTaskID returns the id of the task stored by a client under name.
+func (s *Storage) CalDAVTaskID(name string) (string, error) {
+	var id string
+	err := s.q.QueryRow("SELECT task_id FROM caldav_objects WHERE name=?", name).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrSearchTask
+	}
+	return id, err
+}
+
+func (s *Storage) SaveCalDAVName(ids, name, uid string) error {
+	id, err := parseId(ids)
+	if err != nil {
+		return err
+	}
+	_, err = s.q.Exec("INSERT INTO caldav_objects (task_id, name, uid) VALUES (?, ?, ?)", id, name, uid)
+	return err
+}
@@ -1,13 +1,141 @@
 package main
 
+import "encoding/json"
+
 type Task struct {
 	ID      string `json:"id"`
 	Date    string `json:"date"`
 	Title   string `json:"title"`
 	Comment string `json:"comment"`
 	Repeat  string `json:"repeat"`
+	Version int64  `json:"-"`
+}
+
+// TaskPatch holds the fields of a JSON Merge Patch, nil means "not changed".
+type TaskPatch struct {
+	Date    *string
+	Title   *string
+	Comment *string
+	Repeat  *string
 }
 
 type TaskList struct {
 	Tasks []Task `json:"tasks"`
 }
+
+// TaskFilter selects tasks for listing. Dates are inclusive YYYYMMDD bounds,
+// Search matches title or comment case-insensitively.
+type TaskFilter struct {
+	From      string
+	To        string
+	Search    string
+	Repeating *bool
+	Limit     int
+	Offset    int
+}
+
+type Revision struct {
+	ID        string `json:"id"`
+	TaskID    string `json:"task_id"`
+	Date      string `json:"date"`
+	Title     string `json:"title"`
+	Comment   string `json:"comment"`
+	Repeat    string `json:"repeat"`
+	Version   int64  `json:"version"`
+	CreatedAt string `json:"created_at"`
+}
+
+type RevisionList struct {
+	Revisions []Revision `json:"revisions"`
+}
+
+// Agenda lists the tasks of the days From to To. Overdue holds the tasks
+// planned before today, Days the dates from today on that have tasks. A
+// repeating task is listed on each of its dates in the period.
+type Agenda struct {
+	From    string
+	To      string
+	Overdue []Task
+	Days    []AgendaDay
+}
+
+type AgendaDay struct {
+	Date  string
+	Tasks []Task
+}
+
+// BatchOp is one item of POST /api/tasks/batch. Task is used by create and
+// update, ID by delete and done, a non-zero Version is checked like If-Match.
+type BatchOp struct {
+	Op      string `json:"op"`
+	ID      string `json:"id,omitempty"`
+	Task    *Task  `json:"task,omitempty"`
+	Version int64  `json:"version,omitempty"`
+}
+
+// BatchResult is the outcome of one operation. Replaced is set by
+// RestoreTasks when an existing task was overwritten.
+type BatchResult struct {
+	ID       string
+	Err      error
+	Replaced bool
+}
+
+// Webhook receives the events listed in Events, all of them when it is empty.
+// Secret is only shown when the webhook is created.
+type Webhook struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Secret    string   `json:"secret,omitempty"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// Delivery is one event sent, or still to be sent, to a webhook.
+type Delivery struct {
+	ID            string          `json:"id"`
+	WebhookID     string          `json:"webhook_id"`
+	Event         string          `json:"event"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	ResponseCode  int             `json:"response_code,omitempty"`
+	LastError     string          `json:"last_error,omitempty"`
+	NextAttemptAt string          `json:"next_attempt_at,omitempty"`
+	CreatedAt     string          `json:"created_at"`
+	DeliveredAt   string          `json:"delivered_at,omitempty"`
+
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// CalendarToken opens the calendar feed for one subscriber. Token is only
+// shown when it is created, the database keeps its hash.
+type CalendarToken struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Token     string `json:"token,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// CalDAVObject is a task as a CalDAV resource. Name and UID are the ones
+// the client chose when it created the task, otherwise they are made from
+// the task id.
+type CalDAVObject struct {
+	Name string
+	UID  string
+	Task Task
+}
+
+// TaskChange is the last change of a task, Name is set for deleted tasks.
+type TaskChange struct {
+	TaskID  string
+	Name    string
+	Deleted bool
+}
+
+type IdempotentResponse struct {
+	Hash   string
+	Status int
+	Body   []byte
+}
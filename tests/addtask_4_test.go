@@ -7,7 +7,9 @@ import (
 	"io"
 	"net/http"
 	"net/http/cookiejar"
+	"net/url"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -49,6 +51,19 @@ func requestJSON(apipath string, values map[string]any, method string) ([]byte,
 		client.Jar = jar
 	}
 
+	if id := changedTask(apipath, values, method); id != "" {
+		// The server requires If-Match, send the ETag of the current version
+		// as a client that has just read the task would.
+		get, err := client.Get(getURL("api/task?id=" + url.QueryEscape(id)))
+		if err != nil {
+			return nil, err
+		}
+		get.Body.Close()
+		if tag := get.Header.Get("ETag"); tag != "" {
+			req.Header.Set("If-Match", tag)
+		}
+	}
+
 	resp, err = client.Do(req)
 	if err != nil {
 		return nil, err
@@ -60,6 +75,25 @@ func requestJSON(apipath string, values map[string]any, method string) ([]byte,
 	return io.ReadAll(resp.Body)
 }
 
+// changedTask returns the id of the task that the request changes, if any.
+func changedTask(apipath string, values map[string]any, method string) string {
+	path, query, _ := strings.Cut(apipath, "?")
+	switch {
+	case path == "api/task" && (method == http.MethodPut || method == http.MethodPatch || method == http.MethodDelete):
+	case (path == "api/task/done" || path == "api/task/revert") && method == http.MethodPost:
+	default:
+		return ""
+	}
+	q, _ := url.ParseQuery(query)
+	if id := q.Get("id"); id != "" {
+		return id
+	}
+	if values["id"] != nil {
+		return fmt.Sprint(values["id"])
+	}
+	return ""
+}
+
 func postJSON(apipath string, values map[string]any, method string) (map[string]any, error) {
 	var (
 		m   map[string]any
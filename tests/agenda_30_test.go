@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getAgenda(t *testing.T, query url.Values) (*http.Response, string) {
+	resp, body, err := requestWithHeaders("api/agenda?"+query.Encode(), nil, http.MethodGet, nil)
+	assert.NoError(t, err)
+	return resp, string(body)
+}
+
+// agendaSection returns the part of the agenda from heading to the next one.
+func agendaSection(agenda, heading, next string) string {
+	_, section, ok := strings.Cut(agenda, heading+"\n")
+	if !ok {
+		return ""
+	}
+	section, _, _ = strings.Cut(section, next)
+	return section
+}
+
+func TestAgenda(t *testing.T) {
+	now := time.Now()
+	from := now.AddDate(0, 0, 30)
+	day := func(n int) string { return from.AddDate(0, 0, n).Format(`20060102`) }
+
+	ids := []string{
+		addTask(t, task{date: day(0), title: "Проверить *огнетушители*", comment: "первый этаж\nвторой этаж", repeat: "d 3"}),
+		addTask(t, task{date: day(2), title: "Совещание"}),
+		addTask(t, task{date: day(9), title: "После периода"}),
+	}
+	// The import keeps past dates, so these tasks are overdue.
+	report := importTasks(t, "api/import?format=json", "application/json", []byte(`[
+		{"date": "`+now.AddDate(0, 0, -1).Format(`20060102`)+`", "title": "Полить цветы", "repeat": "d 1"},
+		{"date": "`+now.AddDate(0, 0, -7).Format(`20060102`)+`", "title": "Сдать отчёт"}]`))
+	for _, item := range report.Items {
+		ids = append(ids, item.ID)
+	}
+
+	query := url.Values{"from": {day(0)}, "to": {day(6)}, "lang": {"ru"}}
+	resp, md := getAgenda(t, query)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, md)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/markdown"))
+	assert.True(t, strings.HasPrefix(md, "# Задачи на "+from.Format("02.01.2006")+" – "+from.AddDate(0, 0, 6).Format("02.01.2006")))
+	overdue := agendaSection(md, "## Просрочено", "\n## ")
+	assert.Contains(t, overdue, "\n- [ ] Полить цветы _("+now.AddDate(0, 0, -1).Format("02.01.2006")+", ↻ каждый день)_\n")
+	assert.Contains(t, overdue, "\n- [ ] Сдать отчёт _("+now.AddDate(0, 0, -7).Format("02.01.2006")+")_\n")
+
+	weekdays := []string{"Воскресенье", "Понедельник", "Вторник", "Среда", "Четверг", "Пятница", "Суббота"}
+	heading := func(d time.Time) string {
+		return "## " + weekdays[d.Weekday()] + ", " + d.Format("02.01.2006")
+	}
+	check := "\n- [ ] Проверить \\*огнетушители\\* _(↻ каждые 3 дн.)_\n    первый этаж\n    второй этаж\n"
+	for n := 0; n < 7; n++ {
+		d := from.AddDate(0, 0, n)
+		section := agendaSection(md, heading(d), "\n## ")
+		if n%3 == 0 {
+			assert.Contains(t, section, check, heading(d))
+		} else {
+			assert.NotContains(t, section, "огнетушители", heading(d))
+		}
+		assert.Contains(t, section, "\n- [ ] Полить цветы _(↻ каждый день)_\n", heading(d))
+		assert.NotContains(t, section, "Сдать отчёт", heading(d))
+	}
+
+	// A late repeating task is still planned from today on.
+	_, week := getAgenda(t, url.Values{"lang": {"ru"}})
+	assert.Contains(t, agendaSection(week, heading(now), "\n## "), "Полить цветы")
+	assert.Contains(t, agendaSection(md, heading(from.AddDate(0, 0, 2)), "\n## "), "\n- [ ] Совещание\n")
+	assert.NotContains(t, md, "После периода")
+
+	query.Set("format", "text")
+	query.Set("lang", "en")
+	resp, text := getAgenda(t, query)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain"))
+	assert.Contains(t, text, "Overdue\n")
+	section := agendaSection(text, from.Weekday().String()+", "+from.Format("2006-01-02"), "\n\n")
+	assert.Contains(t, section, "\n  * Проверить *огнетушители* (↻ every 3 days)\n    первый этаж\n")
+
+	for _, q := range []url.Values{
+		{"format": {"pdf"}},
+		{"from": {"2024-01-01"}},
+		{"from": {now.AddDate(0, 0, -1).Format(`20060102`)}},
+		{"from": {day(6)}, "to": {day(0)}},
+		{"from": {day(0)}, "to": {from.AddDate(2, 0, 0).Format(`20060102`)}},
+	} {
+		resp, body, err := requestWithHeaders("api/agenda?"+q.Encode(), nil, http.MethodGet, nil)
+		assert.NoError(t, err)
+		checkError(t, resp, body, http.StatusBadRequest, "bad_format")
+	}
+
+	for _, id := range ids {
+		ret, err := postJSON("api/task?id="+id, nil, http.MethodDelete)
+		assert.NoError(t, err)
+		assert.Empty(t, ret)
+	}
+}
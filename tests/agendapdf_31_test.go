@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var pdfPages = regexp.MustCompile(`/Type /Pages\b[^>]*/Count (\d+)`)
+
+func getAgendaPDF(t *testing.T, query url.Values) (*http.Response, []byte) {
+	resp, body, err := requestWithHeaders("api/agenda.pdf?"+query.Encode(), nil, http.MethodGet, nil)
+	assert.NoError(t, err)
+	return resp, body
+}
+
+func pageCount(t *testing.T, pdf []byte) int {
+	m := pdfPages.FindSubmatch(pdf)
+	if !assert.NotNil(t, m, "no page tree") {
+		return 0
+	}
+	n, _ := strconv.Atoi(string(m[1]))
+	return n
+}
+
+func TestAgendaPDF(t *testing.T) {
+	from := time.Now().AddDate(0, 0, 40)
+	day := func(n int) string { return from.AddDate(0, 0, n).Format(`20060102`) }
+	addTask(t, task{date: day(1), title: "Заказать картриджи для принтера", comment: "два чёрных", repeat: "d 7"})
+
+	resp, body := getAgendaPDF(t, url.Values{"layout": {"day"}, "from": {day(0)}, "to": {day(2)}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Equal(t, "application/pdf", resp.Header.Get("Content-Type"))
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), "agenda-"+day(0)+".pdf")
+	assert.True(t, bytes.HasPrefix(body, []byte("%PDF-")))
+	assert.GreaterOrEqual(t, pageCount(t, body), 3)
+
+	resp, body = getAgendaPDF(t, url.Values{"from": {day(0)}, "to": {day(13)}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, bytes.HasPrefix(body, []byte("%PDF-")))
+
+	resp, body = getAgendaPDF(t, url.Values{"layout": {"month"}, "from": {day(0)}, "to": {day(60)}, "lang": {"en"}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, bytes.HasPrefix(body, []byte("%PDF-")))
+	assert.GreaterOrEqual(t, pageCount(t, body), 2)
+
+	for _, q := range []url.Values{
+		{"layout": {"year"}},
+		{"from": {day(6)}, "to": {day(0)}},
+		{"from": {time.Now().AddDate(0, 0, -3).Format(`20060102`)}, "layout": {"day"}},
+	} {
+		resp, body := getAgendaPDF(t, q)
+		checkError(t, resp, body, http.StatusBadRequest, "bad_format")
+		assert.False(t, strings.HasPrefix(string(body), "%PDF-"))
+	}
+}
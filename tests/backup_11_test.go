@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+// buildApp builds the app into a temporary directory and returns the
+// directory, or "" if the build failed.
+func buildApp(t *testing.T) string {
+	dir := t.TempDir()
+	out, err := exec.Command("go", "build", "-o", filepath.Join(dir, "app"), "..").CombinedOutput()
+	if !assert.NoError(t, err, string(out)) {
+		return ""
+	}
+	return dir
+}
+
+func TestBackup(t *testing.T) {
+	resp, _, err := requestWithHeaders("api/admin/backup", nil, http.MethodGet, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, _, err = requestWithHeaders("api/admin/backup", nil, http.MethodGet,
+		map[string]string{"Authorization": "Bearer wrong-token"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	token := os.Getenv("TODO_ADMIN_TOKEN")
+	if len(token) == 0 {
+		return
+	}
+
+	db := openDB(t)
+	defer db.Close()
+	before, err := count(db)
+	assert.NoError(t, err)
+
+	resp, body, err := requestWithHeaders("api/admin/backup", nil, http.MethodGet,
+		map[string]string{"Authorization": "Bearer " + token})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	fname := filepath.Join(t.TempDir(), "backup.db")
+	assert.NoError(t, os.WriteFile(fname, body, 0o600))
+
+	backup, err := sqlx.Connect("sqlite3", fname)
+	assert.NoError(t, err)
+	defer backup.Close()
+
+	after, err := count(backup)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+}
+
+func TestRestore(t *testing.T) {
+	dir := buildApp(t)
+	if dir == "" {
+		return
+	}
+	fixture, err := os.ReadFile(DBFile)
+	assert.NoError(t, err)
+	write := func(name, data string) {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600))
+	}
+	write("backup.db", string(fixture))
+	write("scheduler.db", "old database")
+	write("scheduler.db-journal", "old journal")
+	write("scheduler.db.bak-wal", "stale wal")
+
+	out, err := runCommand(dir, "", "restore", "backup.db")
+	if !assert.NoError(t, err, out) {
+		return
+	}
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		assert.NoError(t, err)
+		return string(data)
+	}
+	assert.Equal(t, string(fixture), read("scheduler.db"))
+	assert.Equal(t, "old database", read("scheduler.db.bak"))
+	assert.Equal(t, "old journal", read("scheduler.db.bak-journal"))
+	assert.NoFileExists(t, filepath.Join(dir, "scheduler.db-journal"))
+	assert.NoFileExists(t, filepath.Join(dir, "scheduler.db.bak-wal"))
+}
@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type batchResponse struct {
+	Committed bool `json:"committed"`
+	Results   []struct {
+		Index   int    `json:"index"`
+		ID      string `json:"id"`
+		Status  int    `json:"status"`
+		Code    string `json:"code"`
+		Skipped bool   `json:"skipped"`
+	} `json:"results"`
+}
+
+func postBatch(t *testing.T, path string, values map[string]any) (int, batchResponse) {
+	var ret batchResponse
+	resp, body, err := requestWithHeaders(path, values, http.MethodPost, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(body, &ret), string(body))
+	return resp.StatusCode, ret
+}
+
+func TestBatch(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	now := time.Now().Format(`20060102`)
+	doneId := addTask(t, task{date: now, title: "Разобрать почту"})
+	delId := addTask(t, task{date: now, title: "Удалить черновики"})
+
+	status, ret := postBatch(t, "api/tasks/batch", map[string]any{
+		"operations": []map[string]any{
+			{"op": "create", "task": map[string]any{"date": now, "title": "Созданная в пакете"}},
+			{"op": "done", "id": doneId},
+			{"op": "delete", "id": delId},
+		},
+	})
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, ret.Committed)
+	assert.Len(t, ret.Results, 3)
+	if len(ret.Results) == 3 {
+		assert.Equal(t, http.StatusCreated, ret.Results[0].Status)
+		assert.NotEmpty(t, ret.Results[0].ID)
+		assert.Equal(t, http.StatusOK, ret.Results[1].Status)
+	}
+	notFoundTask(t, doneId)
+	notFoundTask(t, delId)
+
+	before, err := count(db)
+	assert.NoError(t, err)
+
+	status, ret = postBatch(t, "api/tasks/batch", map[string]any{
+		"operations": []map[string]any{
+			{"op": "create", "task": map[string]any{"date": now, "title": "Откатится"}},
+			{"op": "done", "id": "7645346343"},
+			{"op": "create", "task": map[string]any{"date": now, "title": "Не выполнится"}},
+		},
+	})
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.False(t, ret.Committed)
+	if assert.Len(t, ret.Results, 3) {
+		assert.Equal(t, "task_not_found", ret.Results[1].Code)
+		assert.True(t, ret.Results[2].Skipped)
+	}
+
+	after, err := count(db)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	ids := []string{
+		addTask(t, task{date: now, title: "Первая"}),
+		addTask(t, task{date: now, title: "Вторая", repeat: "d 1"}),
+	}
+	status, ret = postBatch(t, "api/tasks/done", map[string]any{"ids": ids})
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, ret.Committed)
+	notFoundTask(t, ids[0])
+
+	res, err := db.Exec(`INSERT INTO scheduler (date, title, comment, repeat) VALUES ('20240101', 'Просрочена', '', '')`)
+	assert.NoError(t, err)
+	overdue, err := res.LastInsertId()
+	assert.NoError(t, err)
+
+	m, err := postJSON("api/tasks/reschedule-overdue", nil, http.MethodPost)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, m["ids"])
+
+	var stored Task
+	err = db.Get(&stored, `SELECT * FROM scheduler WHERE id=?`, overdue)
+	assert.NoError(t, err)
+	assert.Equal(t, now, stored.Date)
+}
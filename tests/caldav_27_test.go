@@ -0,0 +1,236 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type davMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Status   string `xml:"status"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				ETag         string `xml:"getetag"`
+				CalendarData string `xml:"calendar-data"`
+				SyncToken    string `xml:"sync-token"`
+				Components   []struct {
+					Name string `xml:"name,attr"`
+				} `xml:"supported-calendar-component-set>comp"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+	SyncToken string `xml:"sync-token"`
+}
+
+func davRequest(t *testing.T, method, path, body string, headers map[string]string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, getURL(path), bytes.NewBufferString(body))
+	assert.NoError(t, err)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if len(Token) > 0 {
+		req.AddCookie(&http.Cookie{Name: "token", Value: Token})
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp, data
+}
+
+func davReport(t *testing.T, path, body string, auth map[string]string) davMultistatus {
+	resp, data := davRequest(t, "REPORT", path, body, auth)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode, string(data))
+	var ms davMultistatus
+	assert.NoError(t, xml.Unmarshal(data, &ms))
+	return ms
+}
+
+func vtodo(uid, date, summary, extra string) string {
+	return strings.ReplaceAll(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//CalDAV//EN
+BEGIN:VTODO
+UID:`+uid+`
+DUE;VALUE=DATE:`+date+`
+SUMMARY:`+summary+`
+`+extra+`END:VTODO
+END:VCALENDAR
+`, "\n", "\r\n")
+}
+
+func TestCalDAV(t *testing.T) {
+	resp, _ := davRequest(t, "PROPFIND", "caldav/", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("WWW-Authenticate"))
+
+	token := os.Getenv("TODO_ADMIN_TOKEN")
+	if len(token) == 0 {
+		return
+	}
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("user", token)
+	auth := map[string]string{"Authorization": req.Header.Get("Authorization")}
+
+	resp, data := davRequest(t, "PROPFIND", "caldav/tasks/", `<?xml version="1.0"?>
+<D:propfind xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><C:supported-calendar-component-set/><D:sync-token/><D:unknown/></D:prop>
+</D:propfind>`, map[string]string{"Authorization": auth["Authorization"], "Depth": "0"})
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode, string(data))
+	var ms davMultistatus
+	assert.NoError(t, xml.Unmarshal(data, &ms))
+	if !assert.Len(t, ms.Responses, 1) {
+		return
+	}
+	collection := ms.Responses[0]
+	assert.Equal(t, "/caldav/tasks/", collection.Href)
+	assert.Len(t, collection.Propstat, 2)
+	assert.Equal(t, "VTODO", collection.Propstat[0].Prop.Components[0].Name)
+	syncToken := collection.Propstat[0].Prop.SyncToken
+	assert.NotEmpty(t, syncToken)
+	assert.Contains(t, collection.Propstat[1].Status, "404")
+
+	// The initial sync lists the whole collection.
+	db := openDB(t)
+	defer db.Close()
+	ms = davReport(t, "caldav/tasks/", `<?xml version="1.0"?>
+<D:sync-collection xmlns:D="DAV:">
+  <D:sync-token/>
+  <D:sync-level>1</D:sync-level>
+  <D:prop><D:getetag/></D:prop>
+</D:sync-collection>`, auth)
+	total, err := count(db)
+	assert.NoError(t, err)
+	assert.Len(t, ms.Responses, total)
+	assert.Equal(t, syncToken, ms.SyncToken)
+
+	date := time.Now().AddDate(0, 0, 3).Format(`20060102`)
+	body := vtodo("client-uid@test", date, "Купить хлеб", "")
+	put := map[string]string{"Authorization": auth["Authorization"], "If-None-Match": "*"}
+	resp, data = davRequest(t, http.MethodPut, "caldav/tasks/client-todo.ics", body, put)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, string(data))
+	assert.Equal(t, `"1"`, resp.Header.Get("ETag"))
+
+	resp, _ = davRequest(t, http.MethodPut, "caldav/tasks/client-todo.ics", body, put)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp, data = davRequest(t, http.MethodGet, "caldav/tasks/client-todo.ics", "", auth)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n ", ""), "\r\n")
+	assert.Contains(t, lines, "UID:client-uid@test")
+	assert.Contains(t, lines, "SUMMARY:Купить хлеб")
+	assert.Contains(t, lines, "DUE;VALUE=DATE:"+date)
+
+	update := map[string]string{"Authorization": auth["Authorization"], "If-Match": `"1"`}
+	resp, _ = davRequest(t, http.MethodPut, "caldav/tasks/client-todo.ics", vtodo("client-uid@test", date, "Купить хлеб и молоко", ""), update)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+
+	resp, _ = davRequest(t, http.MethodPut, "caldav/tasks/client-todo.ics", body, update)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	ms = davReport(t, "caldav/tasks/", `<?xml version="1.0"?>
+<D:sync-collection xmlns:D="DAV:">
+  <D:sync-token>`+syncToken+`</D:sync-token>
+  <D:sync-level>1</D:sync-level>
+  <D:prop><D:getetag/></D:prop>
+</D:sync-collection>`, auth)
+	if assert.Len(t, ms.Responses, 1) {
+		assert.Equal(t, "/caldav/tasks/client-todo.ics", ms.Responses[0].Href)
+		assert.Equal(t, `"2"`, ms.Responses[0].Propstat[0].Prop.ETag)
+	}
+	assert.NotEqual(t, syncToken, ms.SyncToken)
+	syncToken = ms.SyncToken
+
+	id := addTask(t, task{date: date, title: "Задача из API"})
+	ms = davReport(t, "caldav/tasks/", `<?xml version="1.0"?>
+<C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/><C:calendar-data/></D:prop>
+  <D:href>/caldav/tasks/`+id+`.ics</D:href>
+  <D:href>/caldav/tasks/missing.ics</D:href>
+</C:calendar-multiget>`, auth)
+	if assert.Len(t, ms.Responses, 2) {
+		assert.Contains(t, ms.Responses[0].Propstat[0].Prop.CalendarData, "SUMMARY:Задача из API")
+		assert.Contains(t, ms.Responses[1].Status, "404")
+	}
+
+	ms = davReport(t, "caldav/tasks/", `<?xml version="1.0"?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/></D:prop>
+  <C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT"/></C:comp-filter></C:filter>
+</C:calendar-query>`, auth)
+	assert.Empty(t, ms.Responses)
+
+	resp, _ = davRequest(t, http.MethodPut, "caldav/tasks/"+id+".ics", vtodo("task-"+id+"@todo-scheduler", date, "Задача из API", "STATUS:COMPLETED\n"), auth)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	notFoundTask(t, id)
+
+	resp, _ = davRequest(t, http.MethodDelete, "caldav/tasks/client-todo.ics", "", auth)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = davRequest(t, http.MethodGet, "caldav/tasks/client-todo.ics", "", auth)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	ms = davReport(t, "caldav/tasks/", `<?xml version="1.0"?>
+<D:sync-collection xmlns:D="DAV:">
+  <D:sync-token>`+syncToken+`</D:sync-token>
+  <D:prop><D:getetag/></D:prop>
+</D:sync-collection>`, auth)
+	deleted := map[string]string{}
+	for _, r := range ms.Responses {
+		deleted[r.Href] = r.Status
+	}
+	assert.Contains(t, deleted["/caldav/tasks/client-todo.ics"], "404")
+	assert.Contains(t, deleted["/caldav/tasks/"+id+".ics"], "404")
+
+	resp, data = davRequest(t, "REPORT", "caldav/tasks/", `<?xml version="1.0"?>
+<D:sync-collection xmlns:D="DAV:"><D:sync-token>bogus</D:sync-token></D:sync-collection>`, auth)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, string(data), "valid-sync-token")
+
+	// A past date is moved to today, the client gets no ETag and refetches.
+	past := vtodo("past-uid@test", "20240101", "Просроченная из клиента", "")
+	resp, data = davRequest(t, http.MethodPut, "caldav/tasks/past-todo.ics", past, put)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, string(data))
+	assert.Empty(t, resp.Header.Get("ETag"))
+	resp, _ = davRequest(t, http.MethodDelete, "caldav/tasks/past-todo.ics", "", auth)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// Old changes are pruned, their tokens are no longer valid.
+	id = addTask(t, task{date: date, title: "Много изменений"})
+	tx := db.MustBegin()
+	for i := 0; i < 10000; i++ {
+		tx.MustExec(`INSERT INTO task_changes (task_id) VALUES (?)`, id)
+	}
+	assert.NoError(t, tx.Commit())
+	resp, data = davRequest(t, "REPORT", "caldav/tasks/", `<?xml version="1.0"?>
+<D:sync-collection xmlns:D="DAV:"><D:sync-token>`+syncToken+`</D:sync-token></D:sync-collection>`, auth)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, string(data), "valid-sync-token")
+	var kept int
+	assert.NoError(t, db.Get(&kept, `SELECT COUNT(*) FROM task_changes`))
+	assert.Equal(t, 10000, kept)
+	ret, err := postJSON("api/task?id="+id, nil, http.MethodDelete)
+	assert.NoError(t, err)
+	assert.Empty(t, ret)
+
+	// Bodies over the limit are refused rather than cut short.
+	huge := strings.Repeat("x", 5<<20)
+	resp, data = davRequest(t, "REPORT", "caldav/tasks/", `<?xml version="1.0"?><!--`+huge+`-->`, auth)
+	checkError(t, resp, data, http.StatusRequestEntityTooLarge, "file_too_large")
+	resp, data = davRequest(t, http.MethodPut, "caldav/tasks/huge.ics", vtodo("huge", date, huge, ""), auth)
+	checkError(t, resp, data, http.StatusRequestEntityTooLarge, "file_too_large")
+}
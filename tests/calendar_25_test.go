@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// getCalendar returns the unfolded lines of the feed.
+func getCalendar(t *testing.T, query url.Values) (*http.Response, []string) {
+	resp, body, err := requestWithHeaders("api/calendar.ics?"+query.Encode(), nil, http.MethodGet, nil)
+	assert.NoError(t, err)
+	return resp, strings.Split(strings.ReplaceAll(string(body), "\r\n ", ""), "\r\n")
+}
+
+// calendarEntry returns the lines of the entry with uid.
+func calendarEntry(lines []string, uid string) []string {
+	for i, line := range lines {
+		if line == "UID:"+uid {
+			var entry []string
+			for _, l := range lines[i:] {
+				if strings.HasPrefix(l, "END:") {
+					break
+				}
+				entry = append(entry, l)
+			}
+			return append([]string{lines[i-1]}, entry...)
+		}
+	}
+	return nil
+}
+
+func TestCalendar(t *testing.T) {
+	resp, body, err := requestWithHeaders("api/calendar.ics", nil, http.MethodGet, nil)
+	assert.NoError(t, err)
+	checkError(t, resp, body, http.StatusForbidden, "forbidden")
+
+	resp, _ = getCalendar(t, url.Values{"token": {"unknown"}})
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	token := os.Getenv("TODO_ADMIN_TOKEN")
+	if len(token) == 0 {
+		return
+	}
+	auth := map[string]string{"Authorization": "Bearer " + token}
+
+	resp, body, err = requestWithHeaders("api/calendar/tokens", map[string]any{"name": " "}, http.MethodPost, auth)
+	assert.NoError(t, err)
+	checkError(t, resp, body, http.StatusUnprocessableEntity, "name_required")
+
+	resp, body, err = requestWithHeaders("api/calendar/tokens", map[string]any{"name": "Телефон"}, http.MethodPost, auth)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	var feed struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		Token string `json:"token"`
+	}
+	assert.NoError(t, json.Unmarshal(body, &feed))
+	assert.Equal(t, "Телефон", feed.Name)
+	assert.NotEmpty(t, feed.Token)
+
+	resp, body, err = requestWithHeaders("api/calendar/tokens", nil, http.MethodGet, auth)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), feed.ID)
+	assert.NotContains(t, string(body), feed.Token)
+
+	now := time.Now()
+	date := now.Format(`20060102`)
+	repeating := addTask(t, task{date: date, title: "Полить цветы", comment: "Кактус; фикус, пальма", repeat: "d 3"})
+	yearly := addTask(t, task{date: date, title: strings.Repeat("День рождения ", 10), repeat: "y"})
+
+	resp, lines := getCalendar(t, url.Values{"token": {feed.Token}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/calendar"))
+	assert.Equal(t, "BEGIN:VCALENDAR", lines[0])
+	assert.Contains(t, lines, "VERSION:2.0")
+
+	entry := calendarEntry(lines, "task-"+repeating+"@todo-scheduler")
+	assert.Equal(t, "BEGIN:VEVENT", entry[0])
+	assert.Contains(t, entry, "SUMMARY:Полить цветы")
+	assert.Contains(t, entry, `DESCRIPTION:Кактус\; фикус\, пальма`)
+	assert.Contains(t, entry, "DTSTART;VALUE=DATE:"+date)
+	assert.Contains(t, entry, "DTEND;VALUE=DATE:"+now.AddDate(0, 0, 1).Format(`20060102`))
+	assert.Contains(t, entry, "RRULE:FREQ=DAILY;INTERVAL=3")
+
+	entry = calendarEntry(lines, "task-"+yearly+"@todo-scheduler")
+	assert.Contains(t, entry, "SUMMARY:"+strings.Repeat("День рождения ", 10))
+	assert.Contains(t, entry, "RRULE:FREQ=YEARLY")
+
+	resp, lines = getCalendar(t, url.Values{"token": {feed.Token}, "kind": {"todo"}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	entry = calendarEntry(lines, "task-"+repeating+"@todo-scheduler")
+	assert.Equal(t, "BEGIN:VTODO", entry[0])
+	assert.Contains(t, entry, "DUE;VALUE=DATE:"+date)
+
+	resp, _ = getCalendar(t, url.Values{"token": {feed.Token}, "kind": {"journal"}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _, err = requestWithHeaders("api/calendar/tokens/"+feed.ID, nil, http.MethodDelete, auth)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, _ = getCalendar(t, url.Values{"token": {feed.Token}})
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, body, err = requestWithHeaders("api/calendar/tokens/"+feed.ID, nil, http.MethodDelete, auth)
+	assert.NoError(t, err)
+	checkError(t, resp, body, http.StatusNotFound, "calendar_token_not_found")
+}
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+func newKey(t *testing.T, id string) string {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	assert.NoError(t, err)
+	return id + ":" + base64.StdEncoding.EncodeToString(key)
+}
+
+// runCommand runs a command of the app built in dir on the database there.
+func runCommand(dir, key string, args ...string) (string, error) {
+	cmd := exec.Command(filepath.Join(dir, "app"), args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "TODO_ENCRYPTION_KEY="+key, "TODO_ENCRYPTION_KEY_FILE=")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestEncryption(t *testing.T) {
+	id := addTask(t, task{date: time.Now().Format(`20060102`), title: "enc:abc:def"})
+	resp, body, err := requestWithHeaders("api/tasks", nil, http.MethodGet, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Contains(t, string(body), `"title":"enc:abc:def"`)
+	ret, err := postJSON("api/task?id="+id, nil, http.MethodDelete)
+	assert.NoError(t, err)
+	assert.Empty(t, ret)
+
+	dir := buildApp(t)
+	if dir == "" {
+		return
+	}
+
+	k1, k2 := newKey(t, "k1"), newKey(t, "k2")
+	write := func(name, data string) string {
+		fname := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(fname, []byte(data), 0644))
+		return fname
+	}
+	export := func(key string) (string, error) {
+		if out, err := runCommand(dir, key, "todotxt-export", "out.txt"); err != nil {
+			return out, err
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "out.txt"))
+		return string(data), err
+	}
+	lines := []string{"Сверить склад due:2030-01-01", "enc:abc:def due:2030-01-02", "Секрет due:2030-01-03"}
+
+	// Plain text that looks like ciphertext is stored without a key and
+	// stays readable once encryption is on.
+	out, err := runCommand(dir, "", "todotxt-import", write("plain.txt", lines[0]+"\n"+lines[1]+"\n"))
+	if !assert.NoError(t, err, out) {
+		return
+	}
+	out, err = runCommand(dir, k1, "todotxt-import", write("secret.txt", lines[2]+"\n"))
+	if !assert.NoError(t, err, out) {
+		return
+	}
+
+	db, err := sqlx.Connect("sqlite3", filepath.Join(dir, "scheduler.db"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer db.Close()
+	titles := func() []string {
+		var list []string
+		assert.NoError(t, db.Select(&list, `SELECT title FROM scheduler ORDER BY id`))
+		return list
+	}
+	stored := titles()
+	if assert.Len(t, stored, 3) {
+		assert.Equal(t, "Сверить склад", stored[0])
+		assert.Equal(t, "plain:enc:abc:def", stored[1])
+		assert.True(t, strings.HasPrefix(stored[2], "enc:k1:"), stored[2])
+	}
+
+	text, err := export(k1)
+	if !assert.NoError(t, err, text) {
+		return
+	}
+	for _, line := range lines {
+		assert.Contains(t, text, line)
+	}
+
+	// Without the key an encrypted database is not opened at all, so the
+	// ciphertext is never shown or saved as plain text.
+	out, err = runCommand(dir, "", "todotxt-export", "out.txt")
+	assert.Error(t, err)
+	assert.Contains(t, out, "нет ключа")
+	assert.True(t, strings.HasPrefix(titles()[2], "enc:k1:"))
+
+	// A new primary key: old values are read with k1, rekey moves them to k2.
+	out, err = runCommand(dir, k2+","+k1, "rekey")
+	if !assert.NoError(t, err, out) {
+		return
+	}
+	assert.Contains(t, out, "Re-encrypted 3 rows with key k2")
+	for _, title := range titles() {
+		assert.True(t, strings.HasPrefix(title, "enc:k2:"), title)
+	}
+
+	text, err = export(k2)
+	if !assert.NoError(t, err, text) {
+		return
+	}
+	for _, line := range lines {
+		assert.Contains(t, text, line)
+	}
+	_, err = export(k1)
+	assert.Error(t, err, "k1 is retired")
+	_, err = runCommand(dir, "", "rekey")
+	assert.Error(t, err)
+}
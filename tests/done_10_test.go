@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parallelDone(t *testing.T, id string, n int, headers map[string]string) []int {
+	var (
+		wg       sync.WaitGroup
+		statuses = make([]int, n)
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, _, err := requestWithHeaders("api/task/done?id="+id, nil, http.MethodPost, headers)
+			if assert.NoError(t, err) {
+				statuses[i] = resp.StatusCode
+			}
+		}(i)
+	}
+	wg.Wait()
+	return statuses
+}
+
+func countStatus(statuses []int, status int) int {
+	var n int
+	for _, v := range statuses {
+		if v == status {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDoneConcurrent(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	const n = 10
+	now := time.Now()
+
+	id := addTask(t, task{
+		date:   now.Format(`20060102`),
+		title:  "Полить цветы",
+		repeat: "d 3",
+	})
+
+	anyVersion := map[string]string{"If-Match": "*"}
+	statuses := parallelDone(t, id, n, anyVersion)
+	assert.Equal(t, n, countStatus(statuses, http.StatusOK))
+
+	var stored Task
+	err := db.Get(&stored, `SELECT * FROM scheduler WHERE id=?`, id)
+	assert.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, 3*n).Format(`20060102`), stored.Date)
+
+	resp, _, err := requestWithHeaders("api/task?id="+id, nil, http.MethodGet, nil)
+	assert.NoError(t, err)
+	statuses = parallelDone(t, id, n, map[string]string{"If-Match": resp.Header.Get("ETag")})
+	assert.Equal(t, 1, countStatus(statuses, http.StatusOK))
+	assert.Equal(t, n-1, countStatus(statuses, http.StatusPreconditionFailed))
+
+	err = db.Get(&stored, `SELECT * FROM scheduler WHERE id=?`, id)
+	assert.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, 3*(n+1)).Format(`20060102`), stored.Date)
+
+	id = addTask(t, task{
+		date:  now.Format(`20060102`),
+		title: "Отправить счёт",
+	})
+	statuses = parallelDone(t, id, n, anyVersion)
+	assert.Equal(t, 1, countStatus(statuses, http.StatusOK))
+	notFoundTask(t, id)
+}
@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type apiError struct {
+	Error  string            `json:"error"`
+	Code   string            `json:"code"`
+	Fields map[string]string `json:"fields"`
+}
+
+func checkError(t *testing.T, resp *http.Response, body []byte, status int, code string) apiError {
+	var e apiError
+	assert.Equal(t, status, resp.StatusCode, string(body))
+	assert.NoError(t, json.Unmarshal(body, &e), string(body))
+	assert.NotEmpty(t, e.Error)
+	assert.Equal(t, code, e.Code)
+	return e
+}
+
+func TestErrors(t *testing.T) {
+	resp, body, err := requestWithHeaders("api/task?id=7645346343", nil, http.MethodGet, nil)
+	assert.NoError(t, err)
+	checkError(t, resp, body, http.StatusNotFound, "task_not_found")
+
+	resp, body, err = requestWithHeaders("api/task", nil, http.MethodGet, nil)
+	assert.NoError(t, err)
+	checkError(t, resp, body, http.StatusBadRequest, "id_required")
+
+	anyVersion := map[string]string{"If-Match": "*"}
+	resp, body, err = requestWithHeaders(`api/task?id="wjhgese"`, nil, http.MethodDelete, anyVersion)
+	assert.NoError(t, err)
+	checkError(t, resp, body, http.StatusBadRequest, "invalid_id")
+
+	resp, body, err = requestWithHeaders("api/task/done?id=7645346343", nil, http.MethodPost, anyVersion)
+	assert.NoError(t, err)
+	checkError(t, resp, body, http.StatusNotFound, "task_not_found")
+
+	resp, body, err = requestWithHeaders("api/task", map[string]any{
+		"date":  "20240129",
+		"title": "",
+	}, http.MethodPost, nil)
+	assert.NoError(t, err)
+	e := checkError(t, resp, body, http.StatusUnprocessableEntity, "title_required")
+	assert.NotEmpty(t, e.Fields["title"])
+
+	resp, body, err = requestWithHeaders("api/task", map[string]any{
+		"date":   "20240129",
+		"title":  "Заголовок",
+		"repeat": "ooops",
+	}, http.MethodPost, nil)
+	assert.NoError(t, err)
+	e = checkError(t, resp, body, http.StatusUnprocessableEntity, "invalid_repeat")
+	assert.NotEmpty(t, e.Fields["repeat"])
+
+	r, err := http.Post(getURL("api/task"), "application/json", bytes.NewBufferString(`{"title": "`))
+	assert.NoError(t, err)
+	defer r.Body.Close()
+	body, err = io.ReadAll(r.Body)
+	assert.NoError(t, err)
+	checkError(t, r, body, http.StatusBadRequest, "invalid_json")
+}
@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func requestWithHeaders(apipath string, values map[string]any, method string,
+	headers map[string]string) (*http.Response, []byte, error) {
+	var data []byte
+	if len(values) > 0 {
+		var err error
+		data, err = json.Marshal(values)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, getURL(apipath), bytes.NewBuffer(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if len(Token) > 0 {
+		req.AddCookie(&http.Cookie{Name: "token", Value: Token})
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp, body, err
+}
+
+// ifMatch returns the If-Match header with the current ETag of task id.
+func ifMatch(t *testing.T, id string) map[string]string {
+	resp, _, err := requestWithHeaders("api/task?id="+id, nil, http.MethodGet, nil)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	return map[string]string{"If-Match": resp.Header.Get("ETag")}
+}
+
+func TestETag(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	now := time.Now().Format(`20060102`)
+	id := addTask(t, task{
+		date:   now,
+		title:  "Спланировать спринт",
+		repeat: "d 7",
+	})
+
+	resp, _, err := requestWithHeaders("api/task?id="+id, nil, http.MethodGet, nil)
+	assert.NoError(t, err)
+	tag := resp.Header.Get("ETag")
+	assert.NotEmpty(t, tag)
+
+	resp, _, err = requestWithHeaders("api/task?id="+id, nil, http.MethodGet,
+		map[string]string{"If-None-Match": tag})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	upd := map[string]any{
+		"id":    id,
+		"date":  now,
+		"title": "Спланировать спринт с командой",
+	}
+	resp, _, err = requestWithHeaders("api/task", upd, http.MethodPut,
+		map[string]string{"If-Match": tag})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	newTag := resp.Header.Get("ETag")
+	assert.NotEmpty(t, newTag)
+	assert.NotEqual(t, tag, newTag)
+
+	resp, body, err := requestWithHeaders("api/task", upd, http.MethodPut, nil)
+	assert.NoError(t, err)
+	checkError(t, resp, body, http.StatusPreconditionRequired, "if_match_required")
+
+	upd["title"] = "Устаревшая правка"
+	resp, body, err = requestWithHeaders("api/task", upd, http.MethodPut,
+		map[string]string{"If-Match": tag})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	var m map[string]any
+	assert.NoError(t, json.Unmarshal(body, &m))
+	assert.NotEmpty(t, m["error"])
+
+	var task Task
+	err = db.Get(&task, `SELECT * FROM scheduler WHERE id=?`, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Спланировать спринт с командой", task.Title)
+
+	resp, _, err = requestWithHeaders("api/task/done?id="+id, nil, http.MethodPost,
+		map[string]string{"If-Match": tag})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp, _, err = requestWithHeaders("api/task?id="+id, nil, http.MethodDelete,
+		map[string]string{"If-Match": tag})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp, _, err = requestWithHeaders("api/task?id="+id, nil, http.MethodDelete,
+		map[string]string{"If-Match": newTag})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	notFoundTask(t, id)
+}
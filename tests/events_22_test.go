@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type event struct {
+	Name string
+	Data struct {
+		Type    string            `json:"type"`
+		Task    map[string]string `json:"task"`
+		Version int64             `json:"version"`
+		Deleted bool              `json:"deleted"`
+	}
+}
+
+// readEvents sends the events of the stream to the returned channel until
+// the context is cancelled.
+func readEvents(t *testing.T, ctx context.Context) <-chan event {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, getURL("api/events"), nil)
+	assert.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	scanner := bufio.NewScanner(resp.Body)
+	// The retry line is written after the subscription is registered.
+	for scanner.Scan() && !strings.HasPrefix(scanner.Text(), "retry:") {
+	}
+
+	ch := make(chan event, 16)
+	go func() {
+		defer resp.Body.Close()
+		defer close(ch)
+		var e event
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				e.Name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e.Data)
+			case line == "" && e.Name != "":
+				ch <- e
+				e = event{}
+			}
+		}
+	}()
+	return ch
+}
+
+func nextEvent(t *testing.T, ch <-chan event, id string) event {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				t.Fatal("event stream closed")
+			}
+			if e.Data.Task["id"] == id {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("no event for task %s", id)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := readEvents(t, ctx)
+	if ch == nil {
+		return
+	}
+
+	now := time.Now().Format(`20060102`)
+	id := addTask(t, task{date: now, title: "Событие", repeat: "d 1"})
+
+	e := nextEvent(t, ch, id)
+	assert.Equal(t, "created", e.Name)
+	assert.Equal(t, "Событие", e.Data.Task["title"])
+	assert.Equal(t, int64(1), e.Data.Version)
+
+	_, err := postJSON("api/task", map[string]any{
+		"id": id, "date": now, "title": "Событие изменено", "repeat": "d 1",
+	}, http.MethodPut)
+	assert.NoError(t, err)
+	e = nextEvent(t, ch, id)
+	assert.Equal(t, "updated", e.Name)
+	assert.Equal(t, "Событие изменено", e.Data.Task["title"])
+	assert.Equal(t, int64(2), e.Data.Version)
+
+	_, err = postJSON("api/task/done?id="+id, nil, http.MethodPost)
+	assert.NoError(t, err)
+	e = nextEvent(t, ch, id)
+	assert.Equal(t, "done", e.Name)
+	assert.Equal(t, time.Now().AddDate(0, 0, 1).Format(`20060102`), e.Data.Task["date"])
+	assert.False(t, e.Data.Deleted)
+
+	_, err = postJSON("api/task?id="+id, nil, http.MethodDelete)
+	assert.NoError(t, err)
+	e = nextEvent(t, ch, id)
+	assert.Equal(t, "deleted", e.Name)
+	assert.True(t, e.Data.Deleted)
+
+	status, _ := postBatch(t, "api/tasks/batch", map[string]any{
+		"operations": []map[string]any{
+			{"op": "create", "task": map[string]any{"date": now, "title": "Не появится"}},
+			{"op": "done", "id": id},
+		},
+	})
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// Nothing from the rolled back batch may arrive before the next change.
+	other := addTask(t, task{date: now, title: "После отката"})
+	select {
+	case e = <-ch:
+		assert.Equal(t, other, e.Data.Task["id"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event for the new task")
+	}
+}
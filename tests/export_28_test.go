@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// adminAuth returns the Authorization header with the admin token, export
+// and import need it. The test is skipped without the token.
+func adminAuth(t *testing.T) map[string]string {
+	token := os.Getenv("TODO_ADMIN_TOKEN")
+	if len(token) == 0 {
+		t.Skip("TODO_ADMIN_TOKEN is required")
+	}
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+func export(t *testing.T, format string) (*http.Response, []byte) {
+	resp, body, err := requestWithHeaders("api/export?format="+format, nil, http.MethodGet, adminAuth(t))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	return resp, body
+}
+
+func importTasks(t *testing.T, apipath, contentType string, data []byte) importReport {
+	resp, body := upload(t, apipath, contentType, data, adminAuth(t))
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	var report importReport
+	assert.NoError(t, json.Unmarshal(body, &report))
+	return report
+}
+
+func TestExport(t *testing.T) {
+	for _, path := range []string{"api/export", "api/import"} {
+		method := http.MethodGet
+		if path == "api/import" {
+			method = http.MethodPost
+		}
+		resp, body, err := requestWithHeaders(path, nil, method, nil)
+		assert.NoError(t, err)
+		checkError(t, resp, body, http.StatusForbidden, "forbidden")
+	}
+	auth := adminAuth(t)
+
+	date := time.Now().AddDate(0, 0, 5).Format(`20060102`)
+	id := addTask(t, task{date: date, title: "Отчёт, квартал", comment: "строка 1\nстрока \"2\"", repeat: "d 30"})
+	want := map[string]string{"id": id, "date": date, "title": "Отчёт, квартал", "comment": "строка 1\nстрока \"2\"", "repeat": "d 30"}
+
+	resp, body := export(t, "csv")
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/csv"))
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), "tasks.csv")
+	assert.True(t, bytes.HasPrefix(body, []byte("\ufeff")))
+	records, err := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(body, []byte("\ufeff")))).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id", "date", "title", "comment", "repeat"}, records[0])
+	var row []string
+	for _, r := range records[1:] {
+		if r[0] == id {
+			row = r
+		}
+	}
+	assert.Equal(t, []string{id, date, want["title"], want["comment"], "d 30"}, row)
+
+	_, body = export(t, "json")
+	var list struct {
+		Tasks []map[string]string `json:"tasks"`
+	}
+	assert.NoError(t, json.Unmarshal(body, &list))
+	assert.Contains(t, list.Tasks, want)
+
+	_, body = export(t, "jsonl")
+	found := false
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	for sc.Scan() {
+		var task map[string]string
+		assert.NoError(t, json.Unmarshal(sc.Bytes(), &task))
+		found = found || task["id"] == id
+	}
+	assert.True(t, found)
+
+	resp, body, err = requestWithHeaders("api/export?format=xml", nil, http.MethodGet, auth)
+	assert.NoError(t, err)
+	checkError(t, resp, body, http.StatusBadRequest, "bad_format")
+}
+
+func TestImport(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	date := time.Now().AddDate(0, 0, 5).Format(`20060102`)
+	id := addTask(t, task{date: date, title: "Инвентаризация"})
+	existing := `{"id":"` + id + `","date":"` + date + `","title":"Инвентаризация склада","comment":"","repeat":""}`
+
+	var maxID int64
+	assert.NoError(t, db.Get(&maxID, `SELECT max(id) FROM scheduler`))
+	freeID := strconv.FormatInt(maxID+1000, 10)
+	free := `{"id":"` + freeID + `","date":"20200101","title":"Старая задача","repeat":"y","owner":"склад"}`
+
+	before, err := count(db)
+	assert.NoError(t, err)
+
+	data := []byte(existing + "\n\n" + free + "\n" + `{"title":` + "\n")
+	report := importTasks(t, "api/import?format=jsonl&dry_run=true", "application/x-ndjson", data)
+	assert.True(t, report.DryRun)
+	if assert.Len(t, report.Items, 3) {
+		assert.Equal(t, "task_exists", report.Items[0].Code)
+		assert.Equal(t, "valid", report.Items[1].Status)
+		assert.Empty(t, report.Items[1].ID)
+		assert.Equal(t, []string{"owner"}, report.Items[1].Unmapped)
+		assert.Equal(t, "invalid_task", report.Items[2].Code)
+	}
+	after, err := count(db)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	report = importTasks(t, "api/import?format=jsonl", "application/x-ndjson", data)
+	assert.Equal(t, 1, report.Imported)
+	assert.Equal(t, 2, report.Skipped)
+	var stored Task
+	assert.NoError(t, db.Get(&stored, `SELECT * FROM scheduler WHERE id=?`, freeID))
+	assert.Equal(t, "20200101", stored.Date)
+	assert.Equal(t, "Старая задача", stored.Title)
+	assert.Equal(t, "y", stored.Repeat)
+
+	report = importTasks(t, "api/import?conflict=overwrite", "application/json", []byte(`{"tasks":[`+existing+`]}`))
+	if assert.Len(t, report.Items, 1) {
+		assert.Equal(t, "imported", report.Items[0].Status)
+		assert.Equal(t, id, report.Items[0].ID)
+		assert.True(t, report.Items[0].Replaced)
+	}
+	assert.NoError(t, db.Get(&stored, `SELECT * FROM scheduler WHERE id=?`, id))
+	assert.Equal(t, "Инвентаризация склада", stored.Title)
+	assert.Equal(t, int64(2), stored.Version)
+
+	csvData := "\ufeffid,title,date,Примечание\n" + id + ",Копия,20200101,x\n,Новая,20200102,y\n"
+	resp, body := uploadFile(t, "api/import?format=csv&conflict=duplicate", "tasks.csv", []byte(csvData), adminAuth(t))
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	report = importReport{}
+	assert.NoError(t, json.Unmarshal(body, &report))
+	assert.Equal(t, 2, report.Imported)
+	if assert.Len(t, report.Items, 2) {
+		assert.NotEqual(t, id, report.Items[0].ID)
+		assert.Equal(t, "20200101", report.Items[0].Task["date"])
+		assert.Equal(t, []string{"Примечание"}, report.Items[0].Unmapped)
+	}
+	after, err = count(db)
+	assert.NoError(t, err)
+	assert.Equal(t, before+3, after)
+
+	resp, body = upload(t, "api/import?format=csv", "text/csv", []byte("a,b\n1,2\n"), adminAuth(t))
+	checkError(t, resp, body, http.StatusUnprocessableEntity, "invalid_file")
+
+	resp, body = upload(t, "api/import?conflict=merge", "application/json", []byte(`[]`), adminAuth(t))
+	checkError(t, resp, body, http.StatusBadRequest, "bad_format")
+
+	for _, tid := range []string{freeID, report.Items[0].ID, report.Items[1].ID} {
+		_, err = db.Exec(`DELETE FROM scheduler WHERE id=?`, tid)
+		assert.NoError(t, err)
+	}
+}
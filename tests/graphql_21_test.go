@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type graphqlResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string `json:"message"`
+		Extensions struct {
+			Code   string            `json:"code"`
+			Fields map[string]string `json:"fields"`
+		} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func graphqlQuery(t *testing.T, query string, variables map[string]any, data any) graphqlResponse {
+	var ret graphqlResponse
+	resp, body, err := requestWithHeaders("api/graphql", map[string]any{
+		"query":     query,
+		"variables": variables,
+	}, http.MethodPost, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.NoError(t, json.Unmarshal(body, &ret), string(body))
+	if data != nil && len(ret.Errors) == 0 {
+		assert.NoError(t, json.Unmarshal(ret.Data, data), string(ret.Data))
+	}
+	return ret
+}
+
+func TestGraphQL(t *testing.T) {
+	now := time.Now()
+	today := now.Format(`20060102`)
+
+	var added struct {
+		AddTask struct {
+			ID      string `json:"id"`
+			Version int    `json:"version"`
+		} `json:"addTask"`
+	}
+	ret := graphqlQuery(t, `mutation($input: TaskInput!) { addTask(input: $input) { id version } }`,
+		map[string]any{"input": map[string]any{"date": today, "title": "Полить цветы GraphQL", "repeat": "d 3"}}, &added)
+	assert.Empty(t, ret.Errors)
+	id := added.AddTask.ID
+	assert.NotEmpty(t, id)
+
+	var got struct {
+		Task struct {
+			Title       string   `json:"title"`
+			Occurrences []string `json:"occurrences"`
+			Rule        struct {
+				Type string `json:"type"`
+				Days int    `json:"days"`
+			} `json:"rule"`
+			History []struct {
+				Title string `json:"title"`
+			} `json:"history"`
+		} `json:"task"`
+	}
+	ret = graphqlQuery(t, `mutation($id: ID!) {
+		updateTask(id: $id, version: 1, input: {date: "`+today+`", title: "Полить цветы", repeat: "d 3"}) { version }
+	}`, map[string]any{"id": id}, nil)
+	assert.Empty(t, ret.Errors)
+
+	ret = graphqlQuery(t, `query($id: ID!) {
+		task(id: $id) { title occurrences rule { type days } history { title } }
+	}`, map[string]any{"id": id}, &got)
+	assert.Empty(t, ret.Errors)
+	assert.Equal(t, "Полить цветы", got.Task.Title)
+	assert.Equal(t, "d", got.Task.Rule.Type)
+	assert.Equal(t, 3, got.Task.Rule.Days)
+	if assert.Len(t, got.Task.Occurrences, 5) {
+		assert.Equal(t, today, got.Task.Occurrences[0])
+		assert.Equal(t, now.AddDate(0, 0, 12).Format(`20060102`), got.Task.Occurrences[4])
+	}
+	if assert.Len(t, got.Task.History, 1) {
+		assert.Equal(t, "Полить цветы GraphQL", got.Task.History[0].Title)
+	}
+
+	var page struct {
+		Tasks struct {
+			Total int `json:"total"`
+			Tasks []struct {
+				ID string `json:"id"`
+			} `json:"tasks"`
+		} `json:"tasks"`
+	}
+	ret = graphqlQuery(t, `{ tasks(filter: {search: "ПОЛИТЬ ЦВЕТЫ", repeating: true}, limit: 1) { total tasks { id } } }`,
+		nil, &page)
+	assert.Empty(t, ret.Errors)
+	assert.GreaterOrEqual(t, page.Tasks.Total, 1)
+	assert.Len(t, page.Tasks.Tasks, 1)
+
+	ret = graphqlQuery(t, `{ tasks(filter: {from: "2024"}) { total } }`, nil, nil)
+	if assert.Len(t, ret.Errors, 1) {
+		assert.Equal(t, "invalid_date", ret.Errors[0].Extensions.Code)
+	}
+
+	ret = graphqlQuery(t, `{ tasks(limit: 1000) { total } }`, nil, nil)
+	if assert.Len(t, ret.Errors, 1) {
+		assert.Equal(t, "bad_format", ret.Errors[0].Extensions.Code)
+		assert.NotEmpty(t, ret.Errors[0].Extensions.Fields["limit"])
+	}
+
+	ret = graphqlQuery(t, `mutation { addTask(input: {date: "20240101", title: "Неверное правило", repeat: "k 1"}) { id } }`, nil, nil)
+	if assert.Len(t, ret.Errors, 1) {
+		assert.Equal(t, "invalid_repeat", ret.Errors[0].Extensions.Code)
+	}
+
+	ret = graphqlQuery(t, `mutation($id: ID!) { doneTask(id: $id, version: 1) { date } }`,
+		map[string]any{"id": id}, nil)
+	if assert.Len(t, ret.Errors, 1) {
+		assert.Equal(t, "version_mismatch", ret.Errors[0].Extensions.Code)
+	}
+
+	var next struct {
+		NextDate string `json:"nextDate"`
+	}
+	ret = graphqlQuery(t, `{ nextDate(now: "20240126", date: "20240113", repeat: "d 7") }`, nil, &next)
+	assert.Empty(t, ret.Errors)
+	assert.Equal(t, "20240127", next.NextDate)
+
+	var deleted struct {
+		DeleteTask bool `json:"deleteTask"`
+	}
+	ret = graphqlQuery(t, `mutation($id: ID!) { deleteTask(id: $id) }`, map[string]any{"id": id}, &deleted)
+	assert.Empty(t, ret.Errors)
+	assert.True(t, deleted.DeleteTask)
+
+	var missing struct {
+		Task *struct{} `json:"task"`
+	}
+	ret = graphqlQuery(t, `query($id: ID!) { task(id: $id) { id } }`, map[string]any{"id": id}, &missing)
+	assert.Empty(t, ret.Errors)
+	assert.Nil(t, missing.Task)
+}
@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go_final_project/api/todopb"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func grpcReason(err error) string {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+	return ""
+}
+
+func TestGRPC(t *testing.T) {
+	addr := os.Getenv("TODO_GRPC_ADDR")
+	token := os.Getenv("TODO_ADMIN_TOKEN")
+	if addr == "" || token == "" {
+		t.Skip("TODO_GRPC_ADDR and TODO_ADMIN_TOKEN are required")
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	client := todopb.NewTodoClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = client.GetTask(ctx, &todopb.GetTaskRequest{Id: 1})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+
+	now := time.Now().Format(`20060102`)
+	created, err := client.CreateTask(ctx, &todopb.CreateTaskRequest{
+		Task: &todopb.Task{Date: now, Title: "Задача по gRPC", Repeat: "d 2"},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Positive(t, created.Id)
+	assert.Equal(t, int64(1), created.Version)
+
+	got, err := client.GetTask(ctx, &todopb.GetTaskRequest{Id: created.Id})
+	assert.NoError(t, err)
+	assert.Equal(t, "Задача по gRPC", got.GetTitle())
+
+	stream, err := client.ListTasks(ctx, &todopb.ListTasksRequest{})
+	assert.NoError(t, err)
+	found := false
+	for {
+		task, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			break
+		}
+		found = found || task.Id == created.Id
+	}
+	assert.True(t, found)
+
+	_, err = client.UpdateTask(ctx, &todopb.UpdateTaskRequest{
+		Task:    &todopb.Task{Id: created.Id, Date: now, Title: "Устарело"},
+		Version: created.Version + 1,
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Equal(t, "version_mismatch", grpcReason(err))
+
+	updated, err := client.UpdateTask(ctx, &todopb.UpdateTaskRequest{
+		Task:    &todopb.Task{Id: created.Id, Date: now, Title: "Обновлена", Repeat: "d 2"},
+		Version: created.Version,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, created.Version+1, updated.GetVersion())
+
+	_, err = client.CreateTask(ctx, &todopb.CreateTaskRequest{Task: &todopb.Task{Date: now}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "title_required", grpcReason(err))
+
+	done, err := client.DoneTask(ctx, &todopb.DoneTaskRequest{Id: created.Id})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Now().AddDate(0, 0, 2).Format(`20060102`), done.GetTask().GetDate())
+
+	next, err := client.NextDate(ctx, &todopb.NextDateRequest{Now: "20240126", Date: "20240113", Repeat: "d 7"})
+	assert.NoError(t, err)
+	assert.Equal(t, "20240127", next.GetNextDate())
+
+	_, err = client.NextDate(ctx, &todopb.NextDateRequest{Date: "20240113", Repeat: "k 1"})
+	assert.Equal(t, "invalid_repeat", grpcReason(err))
+
+	_, err = client.DeleteTask(ctx, &todopb.DeleteTaskRequest{Id: created.Id})
+	assert.NoError(t, err)
+
+	_, err = client.GetTask(ctx, &todopb.GetTaskRequest{Id: created.Id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "task_not_found", grpcReason(err))
+}
@@ -0,0 +1,34 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorLanguage(t *testing.T) {
+	tbl := []struct {
+		path   string
+		header string
+		code   string
+		want   string
+	}{
+		{"api/task?id=7645346343", "", "task_not_found", "задача не найдена"},
+		{"api/task?id=7645346343", "en-US,en;q=0.9", "task_not_found", "task not found"},
+		{"api/task?id=7645346343", "de-DE, en;q=0.5, ru;q=0.8", "task_not_found", "задача не найдена"},
+		{"api/task?id=7645346343", "de-DE", "task_not_found", "задача не найдена"},
+		{"api/task?id=7645346343&lang=en", "ru", "task_not_found", "task not found"},
+		{"api/task", "en", "id_required", "id is required"},
+	}
+	for _, v := range tbl {
+		var headers map[string]string
+		if v.header != "" {
+			headers = map[string]string{"Accept-Language": v.header}
+		}
+		resp, body, err := requestWithHeaders(v.path, nil, http.MethodGet, headers)
+		assert.NoError(t, err)
+		e := checkError(t, resp, body, resp.StatusCode, v.code)
+		assert.Equal(t, v.want, e.Error, "%s %s", v.path, v.header)
+	}
+}
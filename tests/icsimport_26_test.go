@@ -0,0 +1,221 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type importReport struct {
+	DryRun   bool `json:"dry_run"`
+	Imported int  `json:"imported"`
+	Skipped  int  `json:"skipped"`
+	Items    []struct {
+		Type     string            `json:"type"`
+		UID      string            `json:"uid"`
+		Status   string            `json:"status"`
+		ID       string            `json:"id"`
+		Task     map[string]string `json:"task"`
+		Unmapped []string          `json:"unmapped"`
+		Replaced bool              `json:"replaced"`
+		Code     string            `json:"code"`
+	} `json:"items"`
+}
+
+func upload(t *testing.T, apipath, contentType string, data []byte, headers map[string]string) (*http.Response, []byte) {
+	req, err := http.NewRequest(http.MethodPost, getURL(apipath), bytes.NewReader(data))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if len(Token) > 0 {
+		req.AddCookie(&http.Cookie{Name: "token", Value: Token})
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp, body
+}
+
+func uploadFile(t *testing.T, apipath, name string, data []byte, headers map[string]string) (*http.Response, []byte) {
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	part, err := form.CreateFormFile("file", name)
+	assert.NoError(t, err)
+	part.Write(data)
+	assert.NoError(t, form.Close())
+	return upload(t, apipath, form.FormDataContentType(), buf.Bytes(), headers)
+}
+
+func TestImportICS(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	futureDay := time.Now().AddDate(0, 0, 10)
+	future := futureDay.Format(`20060102`)
+	weekdays := []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+	calendar := strings.ReplaceAll(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//Import//EN
+BEGIN:VTIMEZONE
+TZID:Europe/Moscow
+BEGIN:STANDARD
+DTSTART:19700101T000000
+TZOFFSETFROM:+0300
+TZOFFSETTO:+0300
+END:STANDARD
+END:VTIMEZONE
+BEGIN:VEVENT
+UID:weekly@test
+DTSTART;VALUE=DATE:FUTURE
+SUMMARY:Планёрка\, отдел
+DESCRIPTION:Первая строка\nвторая строка очень длинного описания\, которое
+  перенесено
+LOCATION:Переговорная
+RRULE:FREQ=WEEKLY;BYDAY=WEEKDAY;COUNT=10
+BEGIN:VALARM
+ACTION:DISPLAY
+TRIGGER:-PT15M
+END:VALARM
+END:VEVENT
+BEGIN:VTODO
+UID:todo@test
+DTSTART;TZID=Europe/Moscow:20200101T090000
+DUE;TZID="Europe/Moscow":FUTURET180000
+SUMMARY:Сдать отчёт
+END:VTODO
+BEGIN:VTODO
+UID:done@test
+SUMMARY:Уже сделано
+STATUS:COMPLETED
+END:VTODO
+BEGIN:VEVENT
+UID:past@test
+DTSTART:20200101T100000Z
+SUMMARY:Прошедшая встреча
+END:VEVENT
+BEGIN:VEVENT
+UID:monthly@test
+DTSTART;VALUE=DATE:FUTURE
+SUMMARY:Оплатить счета
+RRULE:FREQ=MONTHLY;BYMONTHDAY=5
+END:VEVENT
+BEGIN:VEVENT
+UID:untitled@test
+DTSTART;VALUE=DATE:FUTURE
+END:VEVENT
+END:VCALENDAR
+`, "FUTURE", future)
+	calendar = strings.ReplaceAll(calendar, "WEEKDAY", weekdays[futureDay.Weekday()])
+	calendar = strings.ReplaceAll(calendar, "\n", "\r\n")
+
+	before, err := count(db)
+	assert.NoError(t, err)
+
+	resp, body := upload(t, "api/import/ics?dry_run=true", "text/calendar", []byte(calendar), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	var report importReport
+	assert.NoError(t, json.Unmarshal(body, &report))
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 3, report.Imported)
+	assert.Equal(t, 3, report.Skipped)
+	if !assert.Len(t, report.Items, 6) {
+		return
+	}
+
+	weekly := report.Items[0]
+	assert.Equal(t, "VEVENT", weekly.Type)
+	assert.Equal(t, "weekly@test", weekly.UID)
+	assert.Equal(t, "valid", weekly.Status)
+	assert.Empty(t, weekly.ID)
+	assert.Equal(t, "Планёрка, отдел", weekly.Task["title"])
+	assert.Equal(t, "Первая строка\nвторая строка очень длинного описания, которое перенесено", weekly.Task["comment"])
+	assert.Equal(t, future, weekly.Task["date"])
+	assert.Equal(t, "d 7", weekly.Task["repeat"])
+	assert.Equal(t, []string{"LOCATION", "VALARM", "RRULE:COUNT"}, weekly.Unmapped)
+
+	todo := report.Items[1]
+	assert.Equal(t, "VTODO", todo.Type)
+	assert.Equal(t, future, todo.Task["date"])
+	assert.Equal(t, "Сдать отчёт", todo.Task["title"])
+
+	assert.Equal(t, "skipped", report.Items[2].Status)
+	assert.Equal(t, "completed", report.Items[2].Code)
+	assert.Equal(t, "past_event", report.Items[3].Code)
+
+	monthly := report.Items[4]
+	assert.Equal(t, "valid", monthly.Status)
+	assert.Empty(t, monthly.Task["repeat"])
+	assert.Equal(t, []string{"RRULE"}, monthly.Unmapped)
+
+	assert.Equal(t, "title_required", report.Items[5].Code)
+
+	after, err := count(db)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	resp, body = uploadFile(t, "api/import/ics", "calendar.ics", []byte(calendar), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	report = importReport{}
+	assert.NoError(t, json.Unmarshal(body, &report))
+	assert.False(t, report.DryRun)
+	assert.Equal(t, 3, report.Imported)
+
+	after, err = count(db)
+	assert.NoError(t, err)
+	assert.Equal(t, before+3, after)
+
+	weekly = report.Items[0]
+	assert.Equal(t, "imported", weekly.Status)
+	var stored Task
+	err = db.Get(&stored, `SELECT * FROM scheduler WHERE id=?`, weekly.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, future, stored.Date)
+	assert.Equal(t, "d 7", stored.Repeat)
+
+	resp, body = upload(t, "api/import/ics", "text/calendar", []byte("SUMMARY:not a calendar"), nil)
+	checkError(t, resp, body, http.StatusUnprocessableEntity, "invalid_calendar")
+
+	resp, body = upload(t, "api/import/ics?dry_run=maybe", "text/calendar", []byte(calendar), nil)
+	checkError(t, resp, body, http.StatusBadRequest, "bad_format")
+
+	// Rules on other days than DTSTART keep the repeat of DTSTART and are reported.
+	other := weekdays[(futureDay.Weekday()+1)%7]
+	month := futureDay.AddDate(0, 1, 0).Month()
+	calendar = strings.ReplaceAll(`BEGIN:VCALENDAR
+BEGIN:VEVENT
+UID:byday@test
+DTSTART;VALUE=DATE:`+future+`
+SUMMARY:Другой день
+RRULE:FREQ=WEEKLY;BYDAY=`+other+`
+END:VEVENT
+BEGIN:VEVENT
+UID:bymonth@test
+DTSTART;VALUE=DATE:`+future+`
+SUMMARY:Другой месяц
+RRULE:FREQ=YEARLY;BYMONTH=`+strconv.Itoa(int(month))+`
+END:VEVENT
+END:VCALENDAR
+`, "\n", "\r\n")
+	report = importTasks(t, "api/import/ics?dry_run=true", "text/calendar", []byte(calendar))
+	if assert.Len(t, report.Items, 2) {
+		assert.Equal(t, "d 7", report.Items[0].Task["repeat"])
+		assert.Equal(t, []string{"RRULE:BYDAY"}, report.Items[0].Unmapped)
+		assert.Equal(t, "y", report.Items[1].Task["repeat"])
+		assert.Equal(t, []string{"RRULE:BYMONTH"}, report.Items[1].Unmapped)
+	}
+}
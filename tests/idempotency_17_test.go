@@ -0,0 +1,53 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	before, err := count(db)
+	assert.NoError(t, err)
+
+	key := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	values := map[string]any{
+		"date":  time.Now().Format(`20060102`),
+		"title": "Заказать канцтовары",
+	}
+	headers := map[string]string{"Idempotency-Key": key}
+
+	resp, first, err := requestWithHeaders("api/task", values, http.MethodPost, headers)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	for i := 0; i < 3; i++ {
+		resp, body, err := requestWithHeaders("api/task", values, http.MethodPost, headers)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, "true", resp.Header.Get("Idempotent-Replayed"))
+		assert.JSONEq(t, string(first), string(body))
+	}
+
+	after, err := count(db)
+	assert.NoError(t, err)
+	assert.Equal(t, before+1, after)
+
+	values["title"] = "Другой запрос с тем же ключом"
+	resp, body, err := requestWithHeaders("api/task", values, http.MethodPost, headers)
+	assert.NoError(t, err)
+	checkError(t, resp, body, http.StatusUnprocessableEntity, "idempotency_key_reused")
+
+	var m map[string]string
+	assert.NoError(t, json.Unmarshal(first, &m))
+	ret, err := postJSON("api/task?id="+m["id"], nil, http.MethodDelete)
+	assert.NoError(t, err)
+	assert.Empty(t, ret)
+}
@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextDateContract(t *testing.T) {
+	tbl := []struct {
+		query  string
+		field  string
+		part   string
+		reason string
+	}{
+		{"now=ooops&date=20240126&repeat=d+1", "now", "", ""},
+		{"now=20240126&repeat=d+1", "date", "", ""},
+		{"now=20240126&date=ooops&repeat=d+1", "date", "", ""},
+		{"now=20240126&date=20240126&repeat=", "repeat", "type", "empty"},
+		{"now=20240126&date=20240126&repeat=k+34", "repeat", "type", "unknown_type"},
+		{"now=20240126&date=20240126&repeat=d", "repeat", "days", "missing"},
+		{"now=20240126&date=20240126&repeat=d+x", "repeat", "days", "not_a_number"},
+		{"now=20240126&date=20240126&repeat=d+401", "repeat", "days", "out_of_range"},
+		{"now=20240126&date=20240126&repeat=y+2", "repeat", "years", "unexpected"},
+	}
+	for _, v := range tbl {
+		resp, body, err := requestWithHeaders("api/nextdate?"+v.query, nil, http.MethodGet, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, v.query)
+
+		var e struct {
+			Error   string            `json:"error"`
+			Fields  map[string]string `json:"fields"`
+			Details map[string]string `json:"details"`
+		}
+		assert.NoError(t, json.Unmarshal(body, &e), v.query)
+		assert.NotEmpty(t, e.Error, v.query)
+		assert.NotEmpty(t, e.Fields[v.field], v.query)
+		assert.Equal(t, v.part, e.Details["part"], v.query)
+		assert.Equal(t, v.reason, e.Details["reason"], v.query)
+	}
+
+	today := time.Now().Format(`20060102`)
+	next, err := getBody("api/nextdate?date=" + today + "&repeat=" + url.QueryEscape("d 1"))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Now().AddDate(0, 0, 1).Format(`20060102`), string(next))
+
+	resp, body, err := requestWithHeaders("api/nextdate?now=20240126&date=20240113&repeat=d+7&format=json",
+		nil, http.MethodGet, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var m struct {
+		NextDate string `json:"next_date"`
+		Rule     struct {
+			Type string `json:"type"`
+			Days int    `json:"days"`
+		} `json:"rule"`
+	}
+	assert.NoError(t, json.Unmarshal(body, &m))
+	assert.Equal(t, "20240127", m.NextDate)
+	assert.Equal(t, "d", m.Rule.Type)
+	assert.Equal(t, 7, m.Rule.Days)
+}
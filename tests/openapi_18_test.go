@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPI(t *testing.T) {
+	body, err := getBody("api/openapi.json")
+	assert.NoError(t, err)
+
+	var doc struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	assert.NoError(t, json.Unmarshal(body, &doc))
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+
+	routes := map[string][]string{
+		"/api/nextdate":                 {"get"},
+		"/api/task":                     {"get", "post", "put", "patch", "delete"},
+		"/api/tasks":                    {"get"},
+		"/api/task/done":                {"post"},
+		"/api/task/revisions":           {"get"},
+		"/api/task/revert":              {"post"},
+		"/api/tasks/batch":              {"post"},
+		"/api/tasks/done":               {"post"},
+		"/api/tasks/reschedule-overdue": {"post"},
+		"/api/admin/backup":             {"get"},
+		"/api/openapi.json":             {"get"},
+		"/api/graphql":                  {"post"},
+		"/api/events":                   {"get"},
+		"/api/ws":                       {"get"},
+		"/api/webhooks":                 {"get", "post"},
+		"/api/webhooks/{id}":            {"delete"},
+		"/api/webhooks/{id}/deliveries": {"get"},
+		"/api/calendar.ics":             {"get"},
+		"/api/calendar/tokens":          {"get", "post"},
+		"/api/calendar/tokens/{id}":     {"delete"},
+		"/api/export":                   {"get"},
+		"/api/agenda":                   {"get"},
+		"/api/agenda.pdf":               {"get"},
+		"/api/import":                   {"post"},
+		"/api/import/ics":               {"post"},
+		"/api/v2/tasks":                 {"get", "post"},
+		"/api/v2/tasks/{id}":            {"get", "put", "patch", "delete"},
+		"/api/v2/tasks/{id}/done":       {"post"},
+		"/.well-known/caldav":           {"get"},
+		"/caldav/":                      {"options"},
+		"/caldav/tasks/":                {"options"},
+		"/caldav/tasks/{name}":          {"get", "put", "delete"},
+	}
+	for path, methods := range routes {
+		for _, method := range methods {
+			assert.Contains(t, doc.Paths[path], method, "%s %s", method, path)
+		}
+	}
+
+	tbl := []struct {
+		method string
+		values map[string]any
+		field  string
+	}{
+		{http.MethodPost, map[string]any{"title": 5}, "title"},
+		{http.MethodPost, map[string]any{"title": "Заголовок", "date": []string{"20240126"}}, "date"},
+		{http.MethodPut, map[string]any{"id": 1, "title": "Заголовок"}, "id"},
+	}
+	for _, v := range tbl {
+		resp, body, err := requestWithHeaders("api/task", v.values, v.method, nil)
+		assert.NoError(t, err)
+		e := checkError(t, resp, body, http.StatusBadRequest, "schema_violation")
+		assert.NotEmpty(t, e.Fields[v.field], "%v", v.values)
+	}
+
+	resp, body, err := requestWithHeaders("api/tasks/batch", map[string]any{
+		"operations": []map[string]any{{"op": "archive", "id": "1"}},
+	}, http.MethodPost, nil)
+	assert.NoError(t, err)
+	e := checkError(t, resp, body, http.StatusBadRequest, "schema_violation")
+	assert.NotEmpty(t, e.Fields["operations[0].op"])
+
+	resp, body, err = requestWithHeaders("api/task", map[string]any{
+		"date": "20240126", "title": "Большая", "comment": strings.Repeat("к", 1<<20),
+	}, http.MethodPost, nil)
+	assert.NoError(t, err)
+	checkError(t, resp, body, http.StatusRequestEntityTooLarge, "file_too_large")
+}
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchTask(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	id := addTask(t, task{
+		title:   "Опечатка в заголвке",
+		comment: "Комментарий",
+		repeat:  "d 5",
+	})
+
+	_, err := db.Exec(`UPDATE scheduler SET date='20240101' WHERE id=?`, id)
+	assert.NoError(t, err)
+
+	resp, body, err := requestWithHeaders("api/task?id="+id, map[string]any{
+		"title": "Опечатка в заголовке",
+	}, http.MethodPatch, ifMatch(t, id))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.NotEmpty(t, resp.Header.Get("ETag"))
+
+	var stored Task
+	err = db.Get(&stored, `SELECT * FROM scheduler WHERE id=?`, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Опечатка в заголовке", stored.Title)
+	assert.Equal(t, "Комментарий", stored.Comment)
+	assert.Equal(t, "d 5", stored.Repeat)
+	assert.Equal(t, "20240101", stored.Date)
+
+	resp, body, err = requestWithHeaders("api/task", map[string]any{
+		"id":      id,
+		"comment": nil,
+		"repeat":  nil,
+	}, http.MethodPatch, ifMatch(t, id))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+
+	err = db.Get(&stored, `SELECT * FROM scheduler WHERE id=?`, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "", stored.Comment)
+	assert.Equal(t, "", stored.Repeat)
+	assert.Equal(t, "Опечатка в заголовке", stored.Title)
+
+	tbl := []struct {
+		values map[string]any
+		status int
+		code   string
+	}{
+		{map[string]any{"title": ""}, http.StatusUnprocessableEntity, "title_required"},
+		{map[string]any{"title": nil}, http.StatusUnprocessableEntity, "title_required"},
+		{map[string]any{"date": "20240192"}, http.StatusUnprocessableEntity, "invalid_date"},
+		{map[string]any{"repeat": "ooops"}, http.StatusUnprocessableEntity, "invalid_repeat"},
+		{map[string]any{"title": 5}, http.StatusBadRequest, "schema_violation"},
+		{map[string]any{"color": "red"}, http.StatusBadRequest, "schema_violation"},
+	}
+	for _, v := range tbl {
+		resp, body, err = requestWithHeaders("api/task?id="+id, v.values, http.MethodPatch, ifMatch(t, id))
+		assert.NoError(t, err)
+		checkError(t, resp, body, v.status, v.code)
+	}
+
+	resp, body, err = requestWithHeaders("api/task", map[string]any{"title": "Без id"}, http.MethodPatch,
+		map[string]string{"If-Match": "*"})
+	assert.NoError(t, err)
+	checkError(t, resp, body, http.StatusBadRequest, "id_required")
+
+	resp, body, err = requestWithHeaders("api/task?id="+id, map[string]any{"title": "Устарело"},
+		http.MethodPatch, map[string]string{"If-Match": `"1"`})
+	assert.NoError(t, err)
+	checkError(t, resp, body, http.StatusPreconditionFailed, "version_mismatch")
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type revision struct {
+	ID      string `json:"id"`
+	TaskID  string `json:"task_id"`
+	Date    string `json:"date"`
+	Title   string `json:"title"`
+	Comment string `json:"comment"`
+	Repeat  string `json:"repeat"`
+}
+
+func getRevisions(t *testing.T, id string) []revision {
+	body, err := requestJSON("api/task/revisions?id="+id, nil, http.MethodGet)
+	assert.NoError(t, err)
+
+	var m map[string][]revision
+	err = json.Unmarshal(body, &m)
+	assert.NoError(t, err)
+	return m["revisions"]
+}
+
+func TestRevisions(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	now := time.Now().Format(`20060102`)
+	id := addTask(t, task{
+		date:    now,
+		title:   "Подготовить отчёт",
+		comment: "Длинная заметка о том, что войдёт в отчёт",
+	})
+
+	revs := getRevisions(t, id)
+	assert.NotNil(t, revs)
+	assert.Empty(t, revs)
+
+	for _, comment := range []string{"Первая правка", "Вторая правка"} {
+		m, err := postJSON("api/task", map[string]any{
+			"id":      id,
+			"date":    now,
+			"title":   "Подготовить отчёт",
+			"comment": comment,
+		}, http.MethodPut)
+		assert.NoError(t, err)
+		_, ok := m["error"]
+		assert.False(t, ok)
+	}
+
+	revs = getRevisions(t, id)
+	assert.Len(t, revs, 2)
+	if len(revs) != 2 {
+		return
+	}
+	assert.Equal(t, "Первая правка", revs[0].Comment)
+	assert.Equal(t, "Длинная заметка о том, что войдёт в отчёт", revs[1].Comment)
+
+	ret, err := postJSON("api/task/revert?id="+id+"&revision="+revs[1].ID, nil, http.MethodPost)
+	assert.NoError(t, err)
+	assert.Empty(t, ret)
+
+	var task Task
+	err = db.Get(&task, `SELECT * FROM scheduler WHERE id=?`, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Длинная заметка о том, что войдёт в отчёт", task.Comment)
+	assert.Len(t, getRevisions(t, id), 3)
+
+	ret, err = postJSON("api/task/revert?id="+id+"&revision=7645346343", nil, http.MethodPost)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, ret["error"])
+
+	body, err := requestJSON("api/task/revisions", nil, http.MethodGet)
+	assert.NoError(t, err)
+	var m map[string]any
+	assert.NoError(t, json.Unmarshal(body, &m))
+	assert.NotEmpty(t, m["error"])
+}
@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTodoTxt(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	due := time.Now().AddDate(0, 0, 7)
+	id := addTask(t, task{date: due.Format(`20060102`), title: "(B) Сверить склад +учёт @офис", comment: "не забыть ключи", repeat: "d 14"})
+
+	resp, body := export(t, "todotxt")
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain"))
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), "todo.txt")
+	line := "(B) Сверить склад +учёт @офис due:" + due.Format(`2006-01-02`) + " rec:2w id:" + id
+	assert.Contains(t, strings.Split(string(body), "\n"), line)
+
+	edited := strings.Replace(line, "(B)", "(A)", 1)
+	data := []byte(edited + "\n" +
+		"x 2024-01-02 2024-01-01 Сделано давно\n" +
+		"2024-01-01 Позвонить поставщику @телефон t:2030-01-01 rec:1m\n" +
+		"Отчёт due:2024-13-01\n")
+
+	report := importTasks(t, "api/import?format=todotxt&conflict=overwrite&dry_run=true", "text/plain", data)
+	if !assert.Len(t, report.Items, 4) {
+		return
+	}
+	assert.True(t, report.Items[0].Replaced)
+	assert.Equal(t, "completed", report.Items[1].Code)
+	call := report.Items[2]
+	assert.Equal(t, "valid", call.Status)
+	assert.Equal(t, "Позвонить поставщику @телефон t:2030-01-01", call.Task["title"])
+	assert.Equal(t, time.Now().Format(`20060102`), call.Task["date"])
+	assert.Empty(t, call.Task["repeat"])
+	assert.Equal(t, []string{"created", "rec"}, call.Unmapped)
+	assert.Equal(t, "invalid_date", report.Items[3].Code)
+
+	report = importTasks(t, "api/import?format=todotxt&conflict=overwrite", "text/plain", data)
+	assert.Equal(t, 2, report.Imported)
+	var stored Task
+	assert.NoError(t, db.Get(&stored, `SELECT * FROM scheduler WHERE id=?`, id))
+	assert.Equal(t, "(A) Сверить склад +учёт @офис", stored.Title)
+	assert.Equal(t, "d 14", stored.Repeat)
+	assert.Equal(t, "не забыть ключи", stored.Comment)
+
+	report = importTasks(t, "api/import?format=todotxt", "text/plain", []byte("Новая due:2030-01-01 rec:3d\n"))
+	if assert.Len(t, report.Items, 1) {
+		assert.Equal(t, "20300101", report.Items[0].Task["date"])
+		assert.Equal(t, "d 3", report.Items[0].Task["repeat"])
+	}
+
+	// Title words that look like todo.txt syntax survive a round trip.
+	titles := []string{"x марки купить", "2024-05-01 годовщина", "(B) 2024-05-01 отчёт",
+		"Перенести due:завтра id:1 rec:2w", `\путь\к\файлу`}
+	ids := map[string]string{}
+	for _, title := range titles {
+		ids[addTask(t, task{date: due.Format(`20060102`), title: title})] = title
+	}
+	_, body = export(t, "todotxt")
+	var lines []string
+	for _, line := range strings.Split(string(body), "\n") {
+		if _, id, _ := strings.Cut(line, " id:"); ids[id] != "" {
+			lines = append(lines, line)
+		}
+	}
+	assert.Len(t, lines, len(titles))
+	report = importTasks(t, "api/import?format=todotxt&conflict=overwrite", "text/plain", []byte(strings.Join(lines, "\n")))
+	assert.Equal(t, len(titles), report.Imported)
+	for id, title := range ids {
+		var stored Task
+		assert.NoError(t, db.Get(&stored, `SELECT * FROM scheduler WHERE id=?`, id))
+		assert.Equal(t, title, stored.Title)
+		assert.Equal(t, due.Format(`20060102`), stored.Date)
+	}
+}
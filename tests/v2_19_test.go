@@ -0,0 +1,171 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type taskV2 struct {
+	ID      int64  `json:"id"`
+	Date    string `json:"date"`
+	Title   string `json:"title"`
+	Comment string `json:"comment"`
+	Repeat  string `json:"repeat"`
+	Version int64  `json:"version"`
+}
+
+type errorV2 struct {
+	Error struct {
+		Code    string            `json:"code"`
+		Message string            `json:"message"`
+		Fields  map[string]string `json:"fields"`
+	} `json:"error"`
+}
+
+func requestV2(t *testing.T, method, path string, values map[string]any,
+	headers map[string]string) (*http.Response, json.RawMessage) {
+	resp, body, err := requestWithHeaders("api/v2/"+path, values, method, headers)
+	assert.NoError(t, err)
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+
+	var env struct {
+		Data json.RawMessage `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(body, &env), string(body))
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp, body
+	}
+	return resp, env.Data
+}
+
+func checkErrorV2(t *testing.T, resp *http.Response, body []byte, status int, code string) errorV2 {
+	var e errorV2
+	assert.Equal(t, status, resp.StatusCode, string(body))
+	assert.NoError(t, json.Unmarshal(body, &e), string(body))
+	assert.Equal(t, code, e.Error.Code)
+	assert.NotEmpty(t, e.Error.Message)
+	return e
+}
+
+func TestV2(t *testing.T) {
+	now := time.Now().Format(`20060102`)
+
+	resp, data := requestV2(t, http.MethodPost, "tasks", map[string]any{
+		"date":   now,
+		"title":  "Задача v2",
+		"repeat": "d 3",
+	}, nil)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, string(data))
+
+	var created taskV2
+	assert.NoError(t, json.Unmarshal(data, &created))
+	assert.Positive(t, created.ID)
+	assert.Equal(t, "Задача v2", created.Title)
+	assert.Equal(t, fmt.Sprintf("/api/v2/tasks/%d", created.ID), resp.Header.Get("Location"))
+	assert.Equal(t, fmt.Sprintf(`"%d"`, created.Version), resp.Header.Get("ETag"))
+
+	path := fmt.Sprintf("tasks/%d", created.ID)
+	resp, data = requestV2(t, http.MethodGet, path, nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var got taskV2
+	assert.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, created, got)
+
+	resp, _ = requestV2(t, http.MethodGet, path, nil, map[string]string{"If-None-Match": resp.Header.Get("ETag")})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	resp, data = requestV2(t, http.MethodPatch, path, map[string]any{"comment": "Дополнение"},
+		map[string]string{"If-Match": fmt.Sprintf(`"%d"`, created.Version)})
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(data))
+	assert.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, "Дополнение", got.Comment)
+	assert.Equal(t, created.Version+1, got.Version)
+
+	resp, body := requestV2(t, http.MethodPut, path, map[string]any{"date": now, "title": "Устарело"},
+		map[string]string{"If-Match": fmt.Sprintf(`"%d"`, created.Version)})
+	checkErrorV2(t, resp, body, http.StatusPreconditionFailed, "version_mismatch")
+
+	resp, data = requestV2(t, http.MethodPut, path, map[string]any{"date": now, "title": "Заменена", "repeat": "d 3"},
+		map[string]string{"If-Match": fmt.Sprintf(`"%d"`, got.Version)})
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(data))
+	assert.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, "Заменена", got.Title)
+	assert.Equal(t, "", got.Comment)
+
+	resp, data = requestV2(t, http.MethodPost, path+"/done", nil, map[string]string{"If-Match": resp.Header.Get("ETag")})
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(data))
+	assert.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, time.Now().AddDate(0, 0, 3).Format(`20060102`), got.Date)
+
+	resp, data = requestV2(t, http.MethodGet, "tasks", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var list []taskV2
+	assert.NoError(t, json.Unmarshal(data, &list))
+	assert.NotEmpty(t, list)
+
+	// Pages follow each other through the next link.
+	type page struct {
+		Data []taskV2 `json:"data"`
+		Next string   `json:"next"`
+	}
+	var first, second page
+	resp, body, err := requestWithHeaders("api/v2/tasks?limit=1", nil, http.MethodGet, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.NoError(t, json.Unmarshal(body, &first))
+	assert.Equal(t, "/api/v2/tasks?limit=1&offset=1", first.Next)
+	resp, body, err = requestWithHeaders(strings.TrimPrefix(first.Next, "/"), nil, http.MethodGet, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.NoError(t, json.Unmarshal(body, &second))
+	if assert.Len(t, first.Data, 1) && assert.Len(t, second.Data, 1) {
+		assert.NotEqual(t, first.Data[0].ID, second.Data[0].ID)
+	}
+	resp, data = requestV2(t, http.MethodGet, "tasks?limit=100&offset=1000000", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "[]", string(data))
+	resp, body = requestV2(t, http.MethodGet, "tasks?limit=0", nil, nil)
+	checkErrorV2(t, resp, body, http.StatusBadRequest, "bad_format")
+
+	resp, data = requestV2(t, http.MethodGet, path+"/revisions", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(data))
+	var revisions []map[string]any
+	assert.NoError(t, json.Unmarshal(data, &revisions))
+	if assert.NotEmpty(t, revisions) {
+		assert.IsType(t, float64(0), revisions[0]["id"])
+		assert.Equal(t, float64(created.ID), revisions[0]["task_id"])
+	}
+
+	resp, _ = requestV2(t, http.MethodDelete, path, nil, map[string]string{"If-Match": fmt.Sprintf(`"%d"`, got.Version)})
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, body = requestV2(t, http.MethodGet, path, nil, nil)
+	checkErrorV2(t, resp, body, http.StatusNotFound, "task_not_found")
+
+	resp, body = requestV2(t, http.MethodGet, "tasks/abc", nil, nil)
+	checkErrorV2(t, resp, body, http.StatusBadRequest, "invalid_id")
+
+	resp, body = requestV2(t, http.MethodPost, "tasks", map[string]any{"date": now}, nil)
+	e := checkErrorV2(t, resp, body, http.StatusUnprocessableEntity, "title_required")
+	assert.NotEmpty(t, e.Error.Fields["title"])
+
+	resp, body = requestV2(t, http.MethodPost, "tasks", map[string]any{"id": "1", "title": "С id"}, nil)
+	checkErrorV2(t, resp, body, http.StatusBadRequest, "schema_violation")
+
+	resp, data = requestV2(t, http.MethodPost, "tasks", map[string]any{"date": now, "title": "Разовая"}, nil)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.NoError(t, json.Unmarshal(data, &created))
+
+	resp, data = requestV2(t, http.MethodPost, fmt.Sprintf("tasks/%d/done", created.ID), nil,
+		map[string]string{"If-Match": fmt.Sprintf(`"%d"`, created.Version)})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "null", string(data))
+}
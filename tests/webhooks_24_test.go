@@ -0,0 +1,253 @@
+package tests
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type hookRequest struct {
+	event     string
+	signature string
+	body      []byte
+	payload   struct {
+		Event   string            `json:"event"`
+		Task    map[string]string `json:"task"`
+		Version int64             `json:"version"`
+		Deleted bool              `json:"deleted"`
+	}
+}
+
+// hookReceiver answers the first request about a task titled failTitle with
+// 500 and the rest with 200. Events of other tests may arrive too, they are
+// dropped when nobody reads them.
+func hookReceiver(t *testing.T, failTitle string) (*httptest.Server, <-chan hookRequest) {
+	ch := make(chan hookRequest, 64)
+	var failed atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := hookRequest{event: r.Header.Get("X-Todo-Event"), signature: r.Header.Get("X-Todo-Signature")}
+		req.body, _ = io.ReadAll(r.Body)
+		json.Unmarshal(req.body, &req.payload)
+		if req.payload.Task["title"] == failTitle && failed.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		select {
+		case ch <- req:
+		default:
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, ch
+}
+
+func nextHook(t *testing.T, ch <-chan hookRequest, event, id string) hookRequest {
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case req := <-ch:
+			if req.event == event && req.payload.Task["id"] == id {
+				return req
+			}
+		case <-timeout:
+			t.Fatalf("no %s webhook for task %s", event, id)
+		}
+	}
+}
+
+func checkSignature(t *testing.T, secret string, req hookRequest) {
+	ts, sig, ok := strings.Cut(req.signature, ",v1=")
+	assert.True(t, ok, req.signature)
+	ts = strings.TrimPrefix(ts, "t=")
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(req.body)
+	assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), sig)
+}
+
+func TestWebhooks(t *testing.T) {
+	resp, _, err := requestWithHeaders("api/webhooks", nil, http.MethodGet, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	token := os.Getenv("TODO_ADMIN_TOKEN")
+	if len(token) == 0 {
+		return
+	}
+	// The test waits for retries, the server must be started with the same
+	// short TODO_WEBHOOK_BACKOFF.
+	backoff, err := time.ParseDuration(os.Getenv("TODO_WEBHOOK_BACKOFF"))
+	if err != nil || backoff > time.Second {
+		t.Skip("TODO_WEBHOOK_BACKOFF of at most 1s is required, e.g. TODO_WEBHOOK_BACKOFF=100ms")
+	}
+	auth := map[string]string{"Authorization": "Bearer " + token}
+
+	resp, body, err := requestWithHeaders("api/webhooks", map[string]any{"url": "ftp://example.com"},
+		http.MethodPost, auth)
+	assert.NoError(t, err)
+	checkError(t, resp, body, http.StatusUnprocessableEntity, "invalid_url")
+
+	resp, body, err = requestWithHeaders("api/webhooks", map[string]any{"url": "http://example.com", "events": []string{"moved"}},
+		http.MethodPost, auth)
+	assert.NoError(t, err)
+	checkError(t, resp, body, http.StatusBadRequest, "schema_violation")
+
+	srv, hooks := hookReceiver(t, "Вебхук")
+	resp, body, err = requestWithHeaders("api/webhooks", map[string]any{"url": srv.URL}, http.MethodPost, auth)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	var hook struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+	assert.NoError(t, json.Unmarshal(body, &hook))
+	assert.NotEmpty(t, hook.ID)
+	assert.NotEmpty(t, hook.Secret)
+	hookPath := "api/webhooks/" + hook.ID
+
+	resp, body, err = requestWithHeaders("api/webhooks", nil, http.MethodGet, auth)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), hook.ID)
+	assert.NotContains(t, string(body), hook.Secret)
+
+	id := addTask(t, task{date: time.Now().Format(`20060102`), title: "Вебхук"})
+
+	// The first attempt gets 500, the retry is delivered.
+	first := nextHook(t, hooks, "created", id)
+	retry := nextHook(t, hooks, "created", id)
+	assert.Equal(t, first.body, retry.body)
+	assert.Equal(t, "Вебхук", retry.payload.Task["title"])
+	assert.Equal(t, int64(1), retry.payload.Version)
+	checkSignature(t, hook.Secret, retry)
+
+	var log struct {
+		Deliveries []struct {
+			ID       string `json:"id"`
+			Event    string `json:"event"`
+			Status   string `json:"status"`
+			Attempts int    `json:"attempts"`
+			Payload  struct {
+				Task map[string]string `json:"task"`
+			} `json:"payload"`
+		} `json:"deliveries"`
+	}
+	var delivery string
+	assert.Eventually(t, func() bool {
+		resp, body, err = requestWithHeaders(hookPath+"/deliveries", nil, http.MethodGet, auth)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.NoError(t, json.Unmarshal(body, &log))
+		for _, d := range log.Deliveries {
+			if d.Event == "created" && d.Payload.Task["id"] == id {
+				delivery = d.ID
+				return d.Status == "delivered" && d.Attempts == 2
+			}
+		}
+		return false
+	}, 5*time.Second, 100*time.Millisecond)
+
+	resp, _, err = requestWithHeaders(fmt.Sprintf("%s/deliveries/%s/redeliver", hookPath, delivery), nil, http.MethodPost, auth)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	again := nextHook(t, hooks, "created", id)
+	assert.Equal(t, retry.body, again.body)
+	checkSignature(t, hook.Secret, again)
+
+	_, err = postJSON("api/task/done?id="+id, nil, http.MethodPost)
+	assert.NoError(t, err)
+	done := nextHook(t, hooks, "done", id)
+	assert.True(t, done.payload.Deleted)
+
+	db := openDB(t)
+	defer db.Close()
+	if os.Getenv("TODO_OVERDUE_INTERVAL") != "" {
+		res, err := db.Exec(`INSERT INTO scheduler (date, title, comment, repeat) VALUES ('20240101', 'Просрочена', '', '')`)
+		assert.NoError(t, err)
+		overdue, err := res.LastInsertId()
+		assert.NoError(t, err)
+		nextHook(t, hooks, "overdue", fmt.Sprint(overdue))
+	}
+
+	resp, _, err = requestWithHeaders(hookPath, nil, http.MethodDelete, auth)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, body, err = requestWithHeaders(hookPath, nil, http.MethodDelete, auth)
+	assert.NoError(t, err)
+	checkError(t, resp, body, http.StatusNotFound, "webhook_not_found")
+
+	// Deliveries are stored with the change, none are lost in a large batch.
+	resp, body, err = requestWithHeaders("api/webhooks", map[string]any{"url": "http://127.0.0.1:1/", "events": []string{"created"}},
+		http.MethodPost, auth)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	var unreachable struct {
+		ID string `json:"id"`
+	}
+	assert.NoError(t, json.Unmarshal(body, &unreachable))
+	ops := make([]map[string]any, 200)
+	for i := range ops {
+		ops[i] = map[string]any{"op": "create", "task": map[string]any{"date": time.Now().Format(`20060102`), "title": fmt.Sprint("Пакет ", i)}}
+	}
+	status, ret := postBatch(t, "api/tasks/batch", map[string]any{"operations": ops})
+	assert.Equal(t, http.StatusOK, status)
+	var queued int
+	assert.NoError(t, db.Get(&queued, `SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id=? AND event='created'`, unreachable.ID))
+	assert.Equal(t, len(ops), queued)
+	for i, res := range ret.Results {
+		ops[i] = map[string]any{"op": "delete", "id": res.ID}
+	}
+	status, _ = postBatch(t, "api/tasks/batch", map[string]any{"operations": ops[:len(ret.Results)]})
+	assert.Equal(t, http.StatusOK, status)
+	resp, _, err = requestWithHeaders("api/webhooks/"+unreachable.ID, nil, http.MethodDelete, auth)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// A slow receiver does not hold up the deliveries to other webhooks.
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+	fast, fastHooks := hookReceiver(t, "")
+	var hookIDs []string
+	for _, url := range []string{slow.URL, fast.URL} {
+		resp, body, err = requestWithHeaders("api/webhooks", map[string]any{"url": url, "events": []string{"created"}},
+			http.MethodPost, auth)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		var created struct {
+			ID string `json:"id"`
+		}
+		assert.NoError(t, json.Unmarshal(body, &created))
+		hookIDs = append(hookIDs, created.ID)
+	}
+	for i := 0; i < 3; i++ {
+		addTask(t, task{date: time.Now().Format(`20060102`), title: fmt.Sprint("Медленный ", i)})
+	}
+	start := time.Now()
+	last := addTask(t, task{date: time.Now().Format(`20060102`), title: "Быстрый"})
+	nextHook(t, fastHooks, "created", last)
+	assert.Less(t, time.Since(start), 3*time.Second)
+	for _, id := range hookIDs {
+		resp, _, err = requestWithHeaders("api/webhooks/"+id, nil, http.MethodDelete, auth)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	}
+}
@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+type wsPeer struct {
+	Session string `json:"session"`
+	User    string `json:"user"`
+}
+
+type wsMessage struct {
+	Type    string   `json:"type"`
+	TaskID  string   `json:"task_id"`
+	Session string   `json:"session"`
+	Viewers []wsPeer `json:"viewers"`
+	Editor  *wsPeer  `json:"editor"`
+	Code    string   `json:"code"`
+	Event   *struct {
+		Type string            `json:"type"`
+		Task map[string]string `json:"task"`
+	} `json:"event"`
+}
+
+func dialWS(t *testing.T, user string) (*websocket.Conn, string) {
+	url := strings.Replace(getURL("api/ws?user="+user), "http://", "ws://", 1)
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	hello := readWS(t, conn, func(m wsMessage) bool { return m.Type == "hello" })
+	assert.NotEmpty(t, hello.Session)
+	return conn, hello.Session
+}
+
+// readWS returns the first message matching ok, skipping the others.
+func readWS(t *testing.T, conn *websocket.Conn, ok func(m wsMessage) bool) wsMessage {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var m wsMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatalf("websocket read: %v", err)
+		}
+		if ok(m) {
+			return m
+		}
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	now := time.Now().Format(`20060102`)
+	id := addTask(t, task{date: now, title: "Обсудить на планировании"})
+
+	alice, aliceSession := dialWS(t, "alice")
+	defer alice.Close()
+	bob, _ := dialWS(t, "bob")
+	defer bob.Close()
+
+	presence := func(m wsMessage) bool { return m.Type == "presence" && m.TaskID == id }
+
+	assert.NoError(t, alice.WriteJSON(map[string]string{"type": "edit", "task_id": id}))
+	m := readWS(t, bob, presence)
+	if assert.NotNil(t, m.Editor) {
+		assert.Equal(t, "alice", m.Editor.User)
+		assert.Equal(t, aliceSession, m.Editor.Session)
+	}
+
+	assert.NoError(t, bob.WriteJSON(map[string]string{"type": "view", "task_id": id}))
+	m = readWS(t, alice, func(m wsMessage) bool { return presence(m) && len(m.Viewers) == 2 })
+	assert.Equal(t, "alice", m.Editor.User)
+
+	assert.NoError(t, bob.WriteJSON(map[string]string{"type": "edit", "task_id": id}))
+	m = readWS(t, bob, func(m wsMessage) bool { return m.Type == "error" })
+	assert.Equal(t, "task_locked", m.Code)
+
+	assert.NoError(t, bob.WriteJSON(map[string]string{"type": "edit", "task_id": "7645346343"}))
+	m = readWS(t, bob, func(m wsMessage) bool { return m.Type == "error" })
+	assert.Equal(t, "task_not_found", m.Code)
+
+	assert.NoError(t, bob.WriteJSON(map[string]string{"type": "subscribe"}))
+	// Messages are handled in order, a reply to the next one means the subscription is in place.
+	assert.NoError(t, bob.WriteJSON(map[string]string{"type": "view", "task_id": id}))
+	readWS(t, bob, presence)
+
+	_, err := postJSON("api/task", map[string]any{"id": id, "date": now, "title": "Обсудили"}, http.MethodPut)
+	assert.NoError(t, err)
+	m = readWS(t, bob, func(m wsMessage) bool { return m.Type == "event" })
+	assert.Equal(t, "updated", m.Event.Type)
+	assert.Equal(t, "Обсудили", m.Event.Task["title"])
+
+	alice.Close()
+	m = readWS(t, bob, func(m wsMessage) bool { return presence(m) && m.Editor == nil })
+	assert.Len(t, m.Viewers, 1)
+
+	assert.NoError(t, bob.WriteJSON(map[string]string{"type": "edit", "task_id": id}))
+	m = readWS(t, bob, presence)
+	if assert.NotNil(t, m.Editor) {
+		assert.Equal(t, "bob", m.Editor.User)
+	}
+
+	resp, body, err := requestWithHeaders("api/ws", nil, http.MethodGet, nil)
+	assert.NoError(t, err)
+	checkError(t, resp, body, http.StatusBadRequest, "bad_format")
+
+	_, err = postJSON("api/task?id="+id, nil, http.MethodDelete)
+	assert.NoError(t, err)
+}
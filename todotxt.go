@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// todo.txt has no fields besides the text, so priority, +project, @context
+// and unknown key:value tags stay in the title as they are. The scheduler
+// reads and writes the due:, rec: and id: tags, comments are not exported.
+// Title words that would be read as a completion mark, a creation date or
+// one of these tags are written with a leading backslash, as are words that
+// start with one.
+
+const todoTxtDate = "2006-01-02"
+
+var (
+	todoTxtPriority = regexp.MustCompile(`^\([A-Z]\)$`)
+	todoTxtRec      = regexp.MustCompile(`^\+?([0-9]+)([a-z])$`)
+)
+
+// writeTodoTxt writes a line per task.
+func writeTodoTxt(buf *bytes.Buffer, tasks []Task) {
+	for _, t := range tasks {
+		buf.WriteString(escapeTodoTxt(t.Title))
+		if d, err := time.Parse("20060102", t.Date); err == nil {
+			buf.WriteString(" due:" + d.Format(todoTxtDate))
+		}
+		if rule, err := ParseRepeat(t.Repeat); t.Repeat != "" && err == nil {
+			buf.WriteString(" rec:" + rule.TodoTxt())
+		}
+		buf.WriteString(" id:" + t.ID + "\n")
+	}
+}
+
+func escapeTodoTxt(title string) string {
+	words := strings.Fields(title)
+	for i, w := range words {
+		key, value, _ := strings.Cut(w, ":")
+		tag := value != "" && (key == "due" || key == "rec" || key == "id")
+		created := isTodoTxtDate(w) && (i == 0 || i == 1 && todoTxtPriority.MatchString(words[0]))
+		if tag || created || i == 0 && w == "x" || strings.HasPrefix(w, `\`) {
+			words[i] = `\` + w
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// parseTodoTxt reads a task per line. A task without due: is planned for
+// today, completed tasks are skipped.
+func parseTodoTxt(data []byte, now time.Time) []importEntry {
+	var entries []importEntry
+	sc := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(data, utf8BOM)))
+	sc.Buffer(nil, maxImportSize)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) > 0 {
+			entries = append(entries, todoTxtEntry(fields, now))
+		}
+	}
+	return entries
+}
+
+func todoTxtEntry(fields []string, now time.Time) importEntry {
+	var (
+		e     importEntry
+		title []string
+	)
+	e.Task.Date = now.Format("20060102")
+
+	if fields[0] == "x" {
+		e.Err = ErrCompleted
+		fields = fields[1:]
+		if len(fields) > 0 && isTodoTxtDate(fields[0]) {
+			fields = fields[1:]
+		}
+	}
+	if len(fields) > 0 && todoTxtPriority.MatchString(fields[0]) {
+		title = append(title, fields[0])
+		fields = fields[1:]
+	}
+	if len(fields) > 0 && isTodoTxtDate(fields[0]) {
+		e.Unmapped = append(e.Unmapped, "created")
+		fields = fields[1:]
+	}
+
+	for _, f := range fields {
+		key, value, _ := strings.Cut(f, ":")
+		switch {
+		case key == "due" && value != "":
+			d, err := time.Parse(todoTxtDate, value)
+			if err != nil && e.Err == nil {
+				e.Err = ErrBadDate
+			}
+			e.Task.Date = d.Format("20060102")
+		case key == "rec" && value != "":
+			if repeat, ok := todoTxtRepeat(value); ok {
+				e.Task.Repeat = repeat
+			} else {
+				e.Unmapped = append(e.Unmapped, "rec")
+			}
+		case key == "id" && value != "":
+			e.Task.ID = value
+		default:
+			title = append(title, strings.TrimPrefix(f, `\`))
+		}
+	}
+	e.Task.Title = strings.Join(title, " ")
+	return e
+}
+
+func isTodoTxtDate(s string) bool {
+	_, err := time.Parse(todoTxtDate, s)
+	return err == nil
+}
+
+// todoTxtRepeat converts a rec: value, months and business days have no
+// repeat rule.
+func todoTxtRepeat(rec string) (string, bool) {
+	m := todoTxtRec.FindStringSubmatch(rec)
+	if m == nil {
+		return "", false
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return "", false
+	}
+
+	var repeat string
+	switch m[2] {
+	case "d":
+		repeat = "d " + strconv.Itoa(n)
+	case "w":
+		repeat = "d " + strconv.Itoa(n*7)
+	case "y":
+		if n != 1 {
+			return "", false
+		}
+		repeat = "y"
+	default:
+		return "", false
+	}
+	if _, err = ParseRepeat(repeat); err != nil {
+		return "", false
+	}
+	return repeat, true
+}
+
+// keepComments copies the stored comments to the tasks with an id, todo.txt
+// has no place for them and overwriting would clear them.
+func keepComments(m TodoList, tasks []Task) {
+	for i := range tasks {
+		if tasks[i].ID == "" {
+			continue
+		}
+		if t, err := m.GetTask(tasks[i].ID); err == nil {
+			tasks[i].Comment = t.Comment
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+const defaultPageSize = 50
+
+// taskV2 is the /api/v2 representation of a task: numeric id and the
+// version that is also sent as the ETag.
+type taskV2 struct {
+	ID      int64  `json:"id"`
+	Date    string `json:"date"`
+	Title   string `json:"title"`
+	Comment string `json:"comment"`
+	Repeat  string `json:"repeat"`
+	Version int64  `json:"version"`
+}
+
+func toTaskV2(t *Task) taskV2 {
+	id, _ := strconv.ParseInt(t.ID, 10, 64)
+	return taskV2{
+		ID:      id,
+		Date:    t.Date,
+		Title:   t.Title,
+		Comment: t.Comment,
+		Repeat:  t.Repeat,
+		Version: t.Version,
+	}
+}
+
+// revisionV2 is an earlier version of a task with numeric ids.
+type revisionV2 struct {
+	ID        int64  `json:"id"`
+	TaskID    int64  `json:"task_id"`
+	Date      string `json:"date"`
+	Title     string `json:"title"`
+	Comment   string `json:"comment"`
+	Repeat    string `json:"repeat"`
+	Version   int64  `json:"version"`
+	CreatedAt string `json:"created_at"`
+}
+
+func toRevisionV2(r *Revision) revisionV2 {
+	id, _ := strconv.ParseInt(r.ID, 10, 64)
+	taskId, _ := strconv.ParseInt(r.TaskID, 10, 64)
+	return revisionV2{
+		ID:        id,
+		TaskID:    taskId,
+		Date:      r.Date,
+		Title:     r.Title,
+		Comment:   r.Comment,
+		Repeat:    r.Repeat,
+		Version:   r.Version,
+		CreatedAt: r.CreatedAt,
+	}
+}
+
+type errorV2 struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Every v2 response body is either {"data": ...} or {"error": {...}}.
+func writeData(w http.ResponseWriter, status int, v any) {
+	writeJSON(w, status, map[string]any{"data": v})
+}
+
+func writeErrorV2(w http.ResponseWriter, r *http.Request, err error) {
+	status, body := errorResponse(w, r, err)
+	writeJSON(w, status, map[string]errorV2{"error": {
+		Code:    body.Code,
+		Message: body.Error,
+		Fields:  body.Fields,
+		Details: body.Details,
+	}})
+}
+
+func (s *Server) startHandlersV2() {
+	http.HandleFunc("GET /api/v2/tasks", s.listTasksV2)
+	http.HandleFunc("GET /api/v2/tasks/{id}", s.getTaskV2)
+	http.HandleFunc("GET /api/v2/tasks/{id}/revisions", s.getRevisionsV2)
+
+	http.HandleFunc("POST /api/v2/tasks", s.validated("TaskInput", s.idempotent(s.createTaskV2)))
+	http.HandleFunc("POST /api/v2/tasks/{id}/done", s.doneTaskV2)
+
+	http.HandleFunc("PUT /api/v2/tasks/{id}", s.validated("TaskInput", s.updateTaskV2))
+	http.HandleFunc("PATCH /api/v2/tasks/{id}", s.validated("TaskPatch", s.patchTaskV2))
+
+	http.HandleFunc("DELETE /api/v2/tasks/{id}", s.deleteTaskV2)
+}
+
+// pathId returns the {id} path segment, which has to be a positive number.
+func pathId(r *http.Request) (string, error) {
+	id := r.PathValue("id")
+	if n, err := strconv.ParseInt(id, 10, 64); err != nil || n <= 0 {
+		return "", ErrId
+	}
+	return id, nil
+}
+
+// pageParam returns the numeric query parameter name, def when it is absent.
+func pageParam(r *http.Request, name string, def, min, max int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || n > max {
+		return 0, &APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: name, Err: ErrBadFormat}
+	}
+	return n, nil
+}
+
+// listTasksV2 returns a page of tasks ordered by date. The envelope has a
+// next link while more tasks follow.
+func (s *Server) listTasksV2(w http.ResponseWriter, r *http.Request) {
+	limit, err := pageParam(r, "limit", defaultPageSize, 1, maxPageSize)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	offset, err := pageParam(r, "offset", 0, 0, math.MaxInt32)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tl, total, err := s.m.FindTasks(TaskFilter{Limit: limit, Offset: offset})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tasks := make([]taskV2, 0, len(tl.Tasks))
+	for i := range tl.Tasks {
+		tasks = append(tasks, toTaskV2(&tl.Tasks[i]))
+	}
+	body := map[string]any{"data": tasks}
+	if offset+limit < total {
+		body["next"] = fmt.Sprintf("/api/v2/tasks?limit=%d&offset=%d", limit, offset+limit)
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (s *Server) getTaskV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathId(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	t, err := s.m.GetTask(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(t.Version))
+	if r.Header.Get("If-None-Match") == etag(t.Version) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeData(w, http.StatusOK, toTaskV2(t))
+}
+
+func (s *Server) getRevisionsV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathId(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	rl, err := s.m.GetRevisions(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	revisions := make([]revisionV2, 0, len(rl.Revisions))
+	for i := range rl.Revisions {
+		revisions = append(revisions, toRevisionV2(&rl.Revisions[i]))
+	}
+	writeData(w, http.StatusOK, revisions)
+}
+
+func (s *Server) createTaskV2(w http.ResponseWriter, r *http.Request) {
+	t := &Task{}
+
+	if err := decodeJSON(r, t); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	t, err := s.m.ValidTaskAndModify(&Task{Date: t.Date, Title: t.Title, Comment: t.Comment, Repeat: t.Repeat})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	id, err := s.m.AddTask(t)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	t, err = s.m.GetTask(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v2/tasks/"+id)
+	w.Header().Set("ETag", etag(t.Version))
+	writeData(w, http.StatusCreated, toTaskV2(t))
+}
+
+func (s *Server) updateTaskV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathId(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	version, err := s.ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	t := &Task{}
+	if err = decodeJSON(r, t); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	t, err = s.m.ValidTaskAndModify(&Task{ID: id, Date: t.Date, Title: t.Title, Comment: t.Comment, Repeat: t.Repeat})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	t.Version = version
+	if err = s.m.UpdateTask(t); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(t.Version))
+	writeData(w, http.StatusOK, toTaskV2(t))
+}
+
+func (s *Server) patchTaskV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathId(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	version, err := s.ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	id, p, err := decodePatch(r, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	t, err := s.m.PatchTask(id, p, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(t.Version))
+	writeData(w, http.StatusOK, toTaskV2(t))
+}
+
+// doneTaskV2 answers with the rescheduled task, or null data when the task
+// had no repeat rule and was deleted.
+func (s *Server) doneTaskV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathId(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	version, err := s.ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	t, err := s.m.DoneTask(id, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if t == nil {
+		writeData(w, http.StatusOK, nil)
+		return
+	}
+	w.Header().Set("ETag", etag(t.Version))
+	writeData(w, http.StatusOK, toTaskV2(t))
+}
+
+func (s *Server) deleteTaskV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathId(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	version, err := s.ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err = s.m.DeleteTask(id, version); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
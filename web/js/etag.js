@@ -0,0 +1,53 @@
+// The server requires If-Match on changes of a task. The ETag is kept from
+// the task card (GET api/task?id=...); marking done or deleting from the
+// list fetches the current one first.
+(function () {
+    var etags = {};
+
+    function taskId(config) {
+        if (config.method === "put" && config.data && typeof config.data === "object") {
+            return String(config.data.id);
+        }
+        var m = /[?&]id=([^&]*)/.exec(config.url);
+        return m ? decodeURIComponent(m[1]) : "";
+    }
+
+    function changesTask(config) {
+        var path = config.url.split("?")[0];
+        return (path === "api/task" && (config.method === "put" || config.method === "delete")) ||
+            (path === "api/task/done" && config.method === "post");
+    }
+
+    axios.interceptors.request.use(function (config) {
+        if (!changesTask(config)) {
+            return config;
+        }
+        var id = taskId(config);
+        config.taskId = id;
+        if (etags[id]) {
+            config.headers["If-Match"] = etags[id];
+            return config;
+        }
+        return axios.get("api/task?id=" + encodeURIComponent(id)).then(function (resp) {
+            if (resp.headers.etag) {
+                config.headers["If-Match"] = resp.headers.etag;
+            }
+            return config;
+        });
+    });
+
+    axios.interceptors.response.use(function (resp) {
+        var config = resp.config;
+        if (config.method === "get" && config.url.split("?")[0] === "api/task" && resp.headers.etag) {
+            etags[taskId(config)] = resp.headers.etag;
+        } else if (config.taskId !== undefined) {
+            delete etags[config.taskId];
+        }
+        return resp;
+    }, function (err) {
+        if (err.config && err.config.taskId !== undefined) {
+            delete etags[err.config.taskId];
+        }
+        return Promise.reject(err);
+    });
+})();
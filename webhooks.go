@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	webhookMaxAttempts = 8
+	webhookMaxBackoff  = 6 * time.Hour
+	webhookBatch       = 20
+	webhookTimeout     = 10 * time.Second
+)
+
+// Dispatcher sends webhook deliveries and looks for overdue tasks. The
+// deliveries are stored by the service in the transaction of the change,
+// so the dispatcher only sends rows that are already in the database and
+// pending ones survive a restart.
+//
+// Every webhook is served by its own goroutine, so a slow receiver does not
+// hold up the others, while the deliveries of one webhook keep their order.
+type Dispatcher struct {
+	svc          *Service
+	client       *http.Client
+	backoff      time.Duration
+	overdueEvery time.Duration
+
+	mu   sync.Mutex
+	busy map[string]bool // webhooks with a goroutine sending to them
+	idle chan struct{}   // signalled when such a goroutine finishes
+}
+
+func NewDispatcher(svc *Service) *Dispatcher {
+	return &Dispatcher{
+		svc:          svc,
+		client:       &http.Client{Timeout: webhookTimeout},
+		backoff:      envDuration("TODO_WEBHOOK_BACKOFF", 30*time.Second),
+		overdueEvery: envDuration("TODO_OVERDUE_INTERVAL", time.Hour),
+		busy:         make(map[string]bool),
+		idle:         make(chan struct{}, 1),
+	}
+}
+
+func (d *Dispatcher) Run() {
+	go d.deliverLoop()
+
+	overdue := time.NewTicker(d.overdueEvery)
+	defer overdue.Stop()
+	for {
+		d.notifyOverdue()
+		<-overdue.C
+	}
+}
+
+func (d *Dispatcher) notifyOverdue() {
+	if err := d.svc.NotifyOverdue(); err != nil {
+		log.Printf("Failed to check overdue tasks: %v", err)
+	}
+}
+
+func (d *Dispatcher) deliverLoop() {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+		case <-d.svc.Queued():
+		case <-d.idle:
+		}
+		for d.deliverDue() == webhookBatch {
+		}
+	}
+}
+
+// deliverDue loads a batch of due deliveries of the webhooks that are not
+// busy and starts sending them. It returns the size of the batch.
+func (d *Dispatcher) deliverDue() int {
+	d.mu.Lock()
+	busy := make([]string, 0, len(d.busy))
+	for id := range d.busy {
+		busy = append(busy, id)
+	}
+	d.mu.Unlock()
+
+	due, err := d.svc.DueDeliveries(webhookBatch, busy)
+	if err != nil {
+		log.Printf("Failed to load webhook deliveries: %v", err)
+		return 0
+	}
+
+	byHook := make(map[string][]*Delivery)
+	for i := range due {
+		byHook[due[i].WebhookID] = append(byHook[due[i].WebhookID], &due[i])
+	}
+	d.mu.Lock()
+	for id, list := range byHook {
+		d.busy[id] = true
+		go d.deliverHook(id, list)
+	}
+	d.mu.Unlock()
+	return len(due)
+}
+
+// deliverHook sends the deliveries of one webhook in order.
+func (d *Dispatcher) deliverHook(id string, list []*Delivery) {
+	for _, dl := range list {
+		d.send(dl)
+		if err := d.svc.SaveDelivery(dl); err != nil {
+			log.Printf("Failed to save webhook delivery %s: %v", dl.ID, err)
+		}
+	}
+
+	d.mu.Lock()
+	delete(d.busy, id)
+	d.mu.Unlock()
+	select {
+	case d.idle <- struct{}{}:
+	default:
+	}
+}
+
+// signature returns the X-Todo-Signature value for payload sent at timestamp:
+// "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<payload>">".
+func signature(secret string, timestamp int64, payload []byte) string {
+	ts := strconv.FormatInt(timestamp, 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(payload)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send makes one attempt and records its outcome in dl. Any 2xx answer
+// counts as delivered, otherwise the next attempt is scheduled with
+// exponential backoff until webhookMaxAttempts is reached.
+func (d *Dispatcher) send(dl *Delivery) {
+	dl.Attempts++
+	err := d.post(dl)
+
+	now := time.Now().UTC()
+	dl.NextAttemptAt = ""
+	if err == nil {
+		dl.Status = "delivered"
+		dl.LastError = ""
+		dl.DeliveredAt = now.Format(time.RFC3339)
+		return
+	}
+
+	dl.LastError = err.Error()
+	if dl.Attempts >= webhookMaxAttempts {
+		dl.Status = "failed"
+		return
+	}
+	delay := min(d.backoff<<(dl.Attempts-1), webhookMaxBackoff)
+	dl.NextAttemptAt = now.Add(delay).Format(time.RFC3339)
+}
+
+func (d *Dispatcher) post(dl *Delivery) error {
+	req, err := http.NewRequest(http.MethodPost, dl.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "todo-scheduler-webhooks")
+	req.Header.Set("X-Todo-Event", dl.Event)
+	req.Header.Set("X-Todo-Delivery", dl.ID)
+	req.Header.Set("X-Todo-Signature", signature(dl.Secret, time.Now().Unix(), dl.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	dl.ResponseCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *Server) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := s.m.GetWebhooks()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"webhooks": hooks})
+}
+
+func (s *Server) createWebhook(w http.ResponseWriter, r *http.Request) {
+	hook := &Webhook{}
+
+	if err := decodeJSON(r, hook); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := s.m.AddWebhook(hook); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, hook)
+}
+
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := s.m.DeleteWebhook(r.PathValue("id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getDeliveries(w http.ResponseWriter, r *http.Request) {
+	list, err := s.m.GetDeliveries(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"deliveries": list})
+}
+
+func (s *Server) redeliver(w http.ResponseWriter, r *http.Request) {
+	if err := s.m.Redeliver(r.PathValue("id"), r.PathValue("delivery")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, struct{}{})
+}
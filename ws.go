@@ -0,0 +1,358 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	lockTTL      = 30 * time.Second
+	wsSendBuffer = 64
+	wsPingEvery  = 25 * time.Second
+	wsMaxMessage = 4096
+	wsMaxUser    = 64
+)
+
+// wsMessage is used in both directions on /api/ws.
+//
+// Client messages: subscribe, unsubscribe, view, edit, leave. view and edit
+// mark the client as looking at or editing TaskID until lockTTL passes,
+// sending them again renews the mark. Only one client may edit a task.
+//
+// Server messages: hello, event, presence, error.
+type wsMessage struct {
+	Type     string     `json:"type"`
+	TaskID   string     `json:"task_id,omitempty"`
+	Session  string     `json:"session,omitempty"`
+	Event    *Event     `json:"event,omitempty"`
+	Viewers  []wsPeer   `json:"viewers,omitempty"`
+	Editor   *wsPeer    `json:"editor,omitempty"`
+	Presence []wsStatus `json:"presence,omitempty"`
+	Code     string     `json:"code,omitempty"`
+	Message  string     `json:"message,omitempty"`
+}
+
+type wsPeer struct {
+	Session string `json:"session"`
+	User    string `json:"user"`
+}
+
+type wsStatus struct {
+	TaskID  string   `json:"task_id"`
+	Viewers []wsPeer `json:"viewers"`
+	Editor  *wsPeer  `json:"editor"`
+}
+
+type wsClient struct {
+	session string
+	user    string
+	lang    string
+	send    chan wsMessage
+	done    chan struct{}
+
+	mu          sync.Mutex
+	unsubscribe func()
+}
+
+func (c *wsClient) peer() wsPeer {
+	return wsPeer{Session: c.session, User: c.user}
+}
+
+// taskPresence holds the expiry of every mark on a task.
+type taskPresence struct {
+	viewers map[*wsClient]time.Time
+	editor  *wsClient
+	until   time.Time
+}
+
+// Hub tracks who views or edits which task. The marks are advisory: they are
+// shown to other clients but don't block changes through the API.
+type Hub struct {
+	mu       sync.Mutex
+	clients  map[*wsClient]struct{}
+	presence map[string]*taskPresence
+}
+
+func NewHub() *Hub {
+	h := &Hub{
+		clients:  make(map[*wsClient]struct{}),
+		presence: make(map[string]*taskPresence),
+	}
+	go h.expire()
+	return h
+}
+
+var upgrader = websocket.Upgrader{}
+
+func (s *Server) ws(w http.ResponseWriter, r *http.Request) {
+	user := strings.TrimSpace(r.URL.Query().Get("user"))
+	if user == "" || len(user) > wsMaxUser {
+		writeError(w, r, &APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: "user", Err: ErrBadFormat})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	session := make([]byte, 8)
+	rand.Read(session)
+	c := &wsClient{
+		session: hex.EncodeToString(session),
+		user:    user,
+		lang:    language(r),
+		send:    make(chan wsMessage, wsSendBuffer),
+		done:    make(chan struct{}),
+	}
+
+	s.hub.join(c)
+	go c.writeLoop(conn)
+	s.readLoop(conn, c)
+
+	c.setSubscription(nil)
+	s.hub.leave(c)
+}
+
+func (s *Server) readLoop(conn *websocket.Conn, c *wsClient) {
+	defer conn.Close()
+
+	conn.SetReadLimit(wsMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(2 * wsPingEvery))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * wsPingEvery))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg wsMessage
+		if err = json.Unmarshal(data, &msg); err != nil {
+			c.error(newAPIError(http.StatusBadRequest, "invalid_json", ErrBadFormat))
+			continue
+		}
+
+		switch msg.Type {
+		case "subscribe":
+			s.subscribe(c)
+		case "unsubscribe":
+			c.setSubscription(nil)
+		case "view", "edit", "leave":
+			if msg.TaskID == "" {
+				c.error(ErrEmptyId)
+				continue
+			}
+			if _, err := s.m.GetTask(msg.TaskID); err != nil {
+				c.error(err)
+				continue
+			}
+			if err := s.hub.mark(c, msg.Type, msg.TaskID); err != nil {
+				c.error(err)
+			}
+		default:
+			c.error(&APIError{Status: http.StatusBadRequest, Code: "bad_format", Field: "type", Err: ErrBadFormat})
+		}
+	}
+}
+
+// subscribe forwards task events to the client until it unsubscribes or
+// disconnects. A deleted task also loses its presence marks.
+func (s *Server) subscribe(c *wsClient) {
+	events, unsubscribe := s.m.Subscribe()
+	c.setSubscription(unsubscribe)
+
+	go func() {
+		for e := range events {
+			if e.Deleted {
+				s.hub.forget(e.Task.ID)
+			}
+			c.push(wsMessage{Type: "event", Event: &e})
+		}
+	}()
+}
+
+func (c *wsClient) setSubscription(unsubscribe func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.unsubscribe != nil {
+		c.unsubscribe()
+	}
+	c.unsubscribe = unsubscribe
+}
+
+func (c *wsClient) writeLoop(conn *websocket.Conn) {
+	ping := time.NewTicker(wsPingEvery)
+	defer ping.Stop()
+	defer conn.Close()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.send:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// push never blocks, a client that stops reading is left behind.
+func (c *wsClient) push(msg wsMessage) {
+	select {
+	case c.send <- msg:
+	default:
+		log.Printf("Dropping websocket message for session %s", c.session)
+	}
+}
+
+func (c *wsClient) error(err error) {
+	e := toAPIError(err)
+	c.push(wsMessage{Type: "error", Code: e.Code, Message: message(c.lang, e.Code, e.Err)})
+}
+
+func (h *Hub) join(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.clients[c] = struct{}{}
+	hello := wsMessage{Type: "hello", Session: c.session, Presence: []wsStatus{}}
+	for id := range h.presence {
+		hello.Presence = append(hello.Presence, h.status(id))
+	}
+	sort.Slice(hello.Presence, func(i, j int) bool { return hello.Presence[i].TaskID < hello.Presence[j].TaskID })
+	c.push(hello)
+}
+
+func (h *Hub) leave(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.clients, c)
+	close(c.done)
+	for id, p := range h.presence {
+		_, viewing := p.viewers[c]
+		if viewing || p.editor == c {
+			delete(p.viewers, c)
+			if p.editor == c {
+				p.editor = nil
+			}
+			h.changed(id)
+		}
+	}
+}
+
+func (h *Hub) mark(c *wsClient, kind, id string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	p := h.presence[id]
+	if p == nil {
+		p = &taskPresence{viewers: make(map[*wsClient]time.Time)}
+		h.presence[id] = p
+	}
+
+	until := time.Now().Add(lockTTL)
+	switch kind {
+	case "view":
+		p.viewers[c] = until
+	case "edit":
+		if p.editor != nil && p.editor != c {
+			return ErrLocked
+		}
+		p.viewers[c] = until
+		p.editor = c
+		p.until = until
+	case "leave":
+		delete(p.viewers, c)
+		if p.editor == c {
+			p.editor = nil
+		}
+	}
+	h.changed(id)
+	return nil
+}
+
+func (h *Hub) forget(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.presence[id]; ok {
+		delete(h.presence, id)
+		for c := range h.clients {
+			c.push(wsMessage{Type: "presence", TaskID: id})
+		}
+	}
+}
+
+// changed sends the presence of task id to everyone, h.mu must be held.
+func (h *Hub) changed(id string) {
+	st := h.status(id)
+	if len(st.Viewers) == 0 && st.Editor == nil {
+		delete(h.presence, id)
+	}
+	for c := range h.clients {
+		c.push(wsMessage{Type: "presence", TaskID: id, Viewers: st.Viewers, Editor: st.Editor})
+	}
+}
+
+func (h *Hub) status(id string) wsStatus {
+	st := wsStatus{TaskID: id, Viewers: []wsPeer{}}
+	p := h.presence[id]
+	if p == nil {
+		return st
+	}
+	for c := range p.viewers {
+		st.Viewers = append(st.Viewers, c.peer())
+	}
+	sort.Slice(st.Viewers, func(i, j int) bool { return st.Viewers[i].Session < st.Viewers[j].Session })
+	if p.editor != nil {
+		editor := p.editor.peer()
+		st.Editor = &editor
+	}
+	return st
+}
+
+func (h *Hub) expire() {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	for now := range tick.C {
+		h.mu.Lock()
+		for id, p := range h.presence {
+			expired := false
+			for c, until := range p.viewers {
+				if now.After(until) {
+					delete(p.viewers, c)
+					expired = true
+				}
+			}
+			if p.editor != nil && now.After(p.until) {
+				p.editor = nil
+				expired = true
+			}
+			if expired {
+				h.changed(id)
+			}
+		}
+		h.mu.Unlock()
+	}
+}